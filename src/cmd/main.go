@@ -13,10 +13,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/api"
 	"github.com/jakerobb/modbus-eth-controller/pkg/server"
@@ -49,7 +55,25 @@ func main() {
 		os.Exit(1)
 	}
 
-	ctx := context.Background()
+	// Interrupting the CLI stops the current program gracefully, running its finally groups.
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx := api.WithDeviceResolver(signalCtx, readDevices())
+	ctx = api.WithProgramResolver(ctx, api.ProgramDir(programDir()))
+
+	values := readParameterValues(programs)
+	for i, program := range programs {
+		bound, err := program.Bind(values)
+		if err != nil {
+			slog.Error("Program is invalid", "path", program.Path, "error", err)
+			os.Exit(1)
+		}
+		programs[i], program = bound, bound
+		if err := program.Validate(ctx); err != nil {
+			slog.Error("Program is invalid", "path", program.Path, "error", err)
+			os.Exit(1)
+		}
+	}
 
 	var err error
 	for _, program := range programs {
@@ -57,7 +81,7 @@ func main() {
 		if program.Debug {
 			programCtx = context.WithValue(ctx, "debug", program.Debug)
 		}
-		err = program.Run(programCtx)
+		err = program.Run(programCtx, api.NewExecution())
 		if err != nil {
 			slog.Error("Execution of program failed", "path", program.Path, "error", err)
 		}
@@ -94,6 +118,9 @@ func readInputPrograms() []*api.Program {
 	}
 
 	for i, filename := range os.Args[1:] {
+		if strings.HasPrefix(filename, "--") {
+			continue
+		}
 		programBytes, err = os.ReadFile(filename)
 		if err != nil {
 			slog.Error("Failed to read program file", "argIndex", i, "file", filename, "error", err)
@@ -110,6 +137,60 @@ func readInputPrograms() []*api.Program {
 	return programs
 }
 
+// readParameterValues reads program parameter values from --name=value arguments. Every program is given every value,
+// so each name must be a parameter of at least one of them.
+func readParameterValues(programs []*api.Program) map[string]string {
+	values := make(map[string]string)
+	for _, arg := range os.Args[1:] {
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name, value, found := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !found {
+			slog.Error("Parameter needs a value, as --name=value", "arg", arg)
+			os.Exit(1)
+		}
+		declared := slices.ContainsFunc(programs, func(program *api.Program) bool {
+			return slices.ContainsFunc(program.Parameters, func(parameter api.Parameter) bool {
+				return parameter.Name == name
+			})
+		})
+		if !declared {
+			slog.Error("Unknown parameter", "name", name)
+			os.Exit(1)
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// readDevices loads named devices from the same place the server would. The file is optional.
+func readDevices() api.Devices {
+	devicesFile := os.Getenv("MODBUS_DEVICES_FILE")
+	if devicesFile == "" {
+		devicesFile = filepath.Join(programDir(), "devices.json")
+	}
+
+	devices, err := api.ParseDevicesFromFile(devicesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return api.Devices{}
+	}
+	if err != nil {
+		slog.Error("Failed to read devices file", "file", devicesFile, "error", err)
+		os.Exit(1)
+	}
+	return devices
+}
+
+// programDir is where the server would find stored programs. run commands in programs given to the CLI call
+// programs from there.
+func programDir() string {
+	if dir := os.Getenv("MODBUS_PROGRAM_DIR"); dir != "" {
+		return dir
+	}
+	return "/etc/modbus"
+}
+
 func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  modbus-eth-controller --server")
@@ -120,4 +201,6 @@ func printUsage() {
 	fmt.Println("      Provide JSON input via stdin.")
 	fmt.Println("  modbus-eth-controller file1.json [file2.json ...]")
 	fmt.Println("      Provide JSON input via one or more file paths.")
+	fmt.Println("  modbus-eth-controller program.json --name=value [--name=value ...]")
+	fmt.Println("      Set the program's parameters.")
 }
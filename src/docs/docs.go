@@ -23,9 +23,38 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/locks": {
+            "get": {
+                "description": "Returns which program owns each relay, followed by the programs queued for relays, in the order\nthey will run. A lock's ` + "`" + `state` + "`" + ` is ` + "`" + `held` + "`" + `, ` + "`" + `waiting` + "`" + `, or ` + "`" + `suspended` + "`" + ` (by a program of higher priority).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "run"
+                ],
+                "summary": "List relay locks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.Lock"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/programs": {
             "get": {
-                "description": "Returns all available programs keyed by slug",
+                "description": "Returns all available programs keyed by slug. Pattern commands, like ` + "`" + `chase` + "`" + `, are shown as written,\nunless ` + "`" + `expanded=true` + "`" + `, which shows the command groups they generate instead.",
                 "produces": [
                     "application/json"
                 ],
@@ -33,6 +62,14 @@ const docTemplate = `{
                     "programs"
                 ],
                 "summary": "List known programs",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Show the command groups generated by pattern commands",
+                        "name": "expanded",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -51,7 +88,7 @@ const docTemplate = `{
         },
         "/run": {
             "post": {
-                "description": "Executes programs in order. You can provide:\n1. A program in the request body\n2. Program slug(s) via the ` + "`" + `program` + "`" + ` query parameter\n3. Both — the body program runs first, then the slugged programs in order",
+                "description": "Executes programs in order. You can provide:\n1. A program in the request body\n2. Program slug(s) via the ` + "`" + `program` + "`" + ` query parameter\n3. Both — the body program runs first, then the slugged programs in order\n\nGroups are separated by ` + "`" + `commandIntervalMillis` + "`" + `, unless a group contains a ` + "`" + `wait` + "`" + ` command, e.g.\n` + "`" + `[{\"command\": \"on\", \"relay\": 7}, {\"command\": \"wait\", \"durationMillis\": 150}]` + "`" + `\n\nPrograms take turns on each relay; programs using different relays run at the same time. A program\nwith a higher ` + "`" + `priority` + "`" + ` suspends conflicting programs of lower priority, which resume afterwards. A\nprogram's ` + "`" + `onConflict` + "`" + ` policy says what happens when another program of the same or higher priority is\nusing one of its relays: ` + "`" + `queue` + "`" + ` (the default) waits, ` + "`" + `reject` + "`" + ` fails with status ` + "`" + `rejected` + "`" + `, and\n` + "`" + `preempt` + "`" + ` cancels the other program and runs once its ` + "`" + `finally` + "`" + ` groups have finished.\n\nTriggers of stored programs with ` + "`" + `debounceMillis` + "`" + `, ` + "`" + `cooldownMillis` + "`" + ` or ` + "`" + `maxRunsPerMinute` + "`" + ` may be\nskipped. Skipped programs are reported with status ` + "`" + `skipped` + "`" + ` and a ` + "`" + `skipReason` + "`" + `.\n\nPrograms that declare ` + "`" + `parameters` + "`" + ` take their values from other query parameters, e.g.\n` + "`" + `/run?program=pulse\u0026relay=3\u0026length=1.5s` + "`" + `, or from a form-encoded body. Parameters without a value\ntake their defaults.\n\nWith ` + "`" + `async=true` + "`" + `, responds immediately with the new run; poll ` + "`" + `/runs/{id}` + "`" + ` for progress and results.",
                 "consumes": [
                     "application/json"
                 ],
@@ -73,6 +110,12 @@ const docTemplate = `{
                         "name": "program",
                         "in": "query"
                     },
+                    {
+                        "type": "boolean",
+                        "description": "Respond immediately instead of waiting for the programs to finish",
+                        "name": "async",
+                        "in": "query"
+                    },
                     {
                         "description": "Inline program to run",
                         "name": "program",
@@ -89,8 +132,14 @@ const docTemplate = `{
                             "$ref": "#/definitions/server.RunResponse"
                         }
                     },
+                    "202": {
+                        "description": "if async=true",
+                        "schema": {
+                            "$ref": "#/definitions/server.Run"
+                        }
+                    },
                     "400": {
-                        "description": "if the request body is malformed",
+                        "description": "if the request body is malformed or a program is invalid",
                         "schema": {
                             "$ref": "#/definitions/server.ErrorResponse"
                         }
@@ -101,6 +150,162 @@ const docTemplate = `{
                             "$ref": "#/definitions/server.ErrorResponse"
                         }
                     },
+                    "409": {
+                        "description": "if a program with onConflict=reject found its relays in use",
+                        "schema": {
+                            "$ref": "#/definitions/server.RunResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/runs": {
+            "get": {
+                "description": "Returns active runs, oldest first, followed by up to 50 recently finished runs, most recent first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "run"
+                ],
+                "summary": "List runs",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/server.Run"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/runs/{id}": {
+            "get": {
+                "description": "GET returns the run's progress and results. Results for programs that haven't finished yet have no\nstatus; the running program's ` + "`" + `execution` + "`" + ` shows the command groups it has run so far.\n\nDELETE cancels the run. Its programs stop as soon as possible, end any pending pulses, and run their\n` + "`" + `finally` + "`" + ` groups; the run's state becomes ` + "`" + `cancelled` + "`" + ` once they have done so.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "run"
+                ],
+                "summary": "Get or cancel a run",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Run ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.Run"
+                        }
+                    },
+                    "202": {
+                        "description": "if the run was cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/server.Run"
+                        }
+                    },
+                    "404": {
+                        "description": "if the run is not found",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "if the run has already finished",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "GET returns the run's progress and results. Results for programs that haven't finished yet have no\nstatus; the running program's ` + "`" + `execution` + "`" + ` shows the command groups it has run so far.\n\nDELETE cancels the run. Its programs stop as soon as possible, end any pending pulses, and run their\n` + "`" + `finally` + "`" + ` groups; the run's state becomes ` + "`" + `cancelled` + "`" + ` once they have done so.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "run"
+                ],
+                "summary": "Get or cancel a run",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Run ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.Run"
+                        }
+                    },
+                    "202": {
+                        "description": "if the run was cancelled",
+                        "schema": {
+                            "$ref": "#/definitions/server.Run"
+                        }
+                    },
+                    "404": {
+                        "description": "if the run is not found",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "if the run has already finished",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedules": {
+            "get": {
+                "description": "Returns the schedules from the schedules file and from programs' ` + "`" + `schedule` + "`" + ` fields, soonest first,\nwith their next fire times in the scheduler's time zone. For ` + "`" + `sunrise` + "`" + ` and ` + "`" + `sunset` + "`" + ` schedules, this is\nthe computed time of the next event, plus the offset. ` + "`" + `lastRunId` + "`" + ` identifies the most recent scheduled\nrun; see ` + "`" + `/runs/{id}` + "`" + `. Schedules that can't fire, or whose programs can't run, have an\n` + "`" + `error` + "`" + `.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "List schedules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/server.Schedule"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -123,7 +328,7 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Modbus device IP or hostname and port number",
+                        "description": "Device name, or Modbus device IP or hostname and port number",
                         "name": "address",
                         "in": "query",
                         "required": true
@@ -136,6 +341,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/modbus.CoilStates"
                         }
                     },
+                    "400": {
+                        "description": "if no address is given",
+                        "schema": {
+                            "$ref": "#/definitions/server.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -158,24 +369,30 @@ const docTemplate = `{
                     ],
                     "example": "toggle"
                 },
-                "relay": {
+                "condition": {
+                    "$ref": "#/definitions/api.Condition"
+                },
+                "count": {
                     "type": "integer",
-                    "example": 1
-                }
-            }
-        },
-        "api.Program": {
-            "type": "object",
-            "properties": {
-                "address": {
+                    "example": 8
+                },
+                "device": {
                     "type": "string",
-                    "example": "modbus.lan:4196"
+                    "example": "garage"
                 },
-                "commandIntervalMillis": {
+                "direction": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.PatternDirection"
+                        }
+                    ],
+                    "example": "forward"
+                },
+                "durationMillis": {
                     "type": "integer",
-                    "example": 200
+                    "example": 150
                 },
-                "commands": {
+                "else": {
                     "type": "array",
                     "items": {
                         "type": "array",
@@ -184,72 +401,761 @@ const docTemplate = `{
                         }
                     }
                 },
-                "debug": {
+                "parallel": {
                     "type": "boolean",
-                    "example": true
+                    "example": false
                 },
-                "lastModified": {
+                "programs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "all-off"
+                    ]
+                },
+                "relay": {
                     "type": "string",
-                    "example": "2025-09-14T12:00:00Z"
+                    "example": "1"
                 },
-                "loops": {
+                "relays": {
+                    "description": "Relays are the relays of the pattern, in order. Count is a shorthand for relays 1 to Count.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "1",
+                        "2",
+                        "3",
+                        "4"
+                    ]
+                },
+                "seed": {
                     "type": "integer",
-                    "example": 2
+                    "example": 42
                 },
-                "path": {
+                "state": {
                     "type": "string",
-                    "example": "/etc/modbus/doorbell.json"
+                    "example": "0b00010101"
                 },
-                "slug": {
+                "steps": {
+                    "description": "Steps is the number of groups for random, which defaults to one per relay, and binaryCounter, which defaults to\ncounting through every combination.",
+                    "type": "integer",
+                    "example": 16
+                },
+                "then": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/api.Command"
+                        }
+                    }
+                },
+                "timeoutMillis": {
+                    "type": "integer",
+                    "example": 30000
+                },
+                "verify": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "width": {
+                    "type": "integer",
+                    "example": 3
+                }
+            }
+        },
+        "api.Condition": {
+            "type": "object",
+            "properties": {
+                "device": {
                     "type": "string",
-                    "example": "doorbell"
+                    "example": "garage"
+                },
+                "input": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "is": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ConditionState"
+                        }
+                    ],
+                    "example": "on"
+                },
+                "relay": {
+                    "type": "string",
+                    "example": "3"
                 }
             }
         },
-        "api.RelayCommand": {
+        "api.ConditionState": {
             "type": "string",
             "enum": [
                 "on",
-                "off",
-                "toggle"
+                "off"
             ],
             "x-enum-varnames": [
-                "RelayCommandOn",
-                "RelayCommandOff",
-                "RelayCommandToggle"
+                "ConditionOn",
+                "ConditionOff"
             ]
         },
-        "modbus.CoilStates": {
-            "type": "object",
-            "properties": {
-                "coils": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "boolean"
-                    }
-                }
-            }
+        "api.ConflictPolicy": {
+            "type": "string",
+            "enum": [
+                "queue",
+                "reject",
+                "preempt"
+            ],
+            "x-enum-varnames": [
+                "ConflictQueue",
+                "ConflictReject",
+                "ConflictPreempt"
+            ]
         },
-        "server.ErrorResponse": {
+        "api.Decision": {
             "type": "object",
             "properties": {
-                "message": {
+                "branch": {
                     "type": "string",
-                    "example": "Invalid relay number"
+                    "example": "loop 1, command group 3 then"
                 },
-                "status": {
+                "command": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.RelayCommand"
+                        }
+                    ],
+                    "example": "if"
+                },
+                "condition": {
+                    "type": "string",
+                    "example": "relay 3 is on"
+                },
+                "finally": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "group": {
                     "type": "integer",
-                    "example": 500
-                }
+                    "example": 3
+                },
+                "loop": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "result": {
+                    "description": "Result is whether the condition held: for if, when it was read, and for waitFor, when the wait ended.",
+                    "type": "boolean",
+                    "example": true
+                },
+                "taken": {
+                    "description": "Taken is the branch an if ran: \"then\" or \"else\".",
+                    "type": "string",
+                    "example": "then"
+                },
+                "time": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00.4Z"
+                },
+                "timedOut": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "waitedMillis": {
+                    "type": "integer",
+                    "example": 1200
+                }
+            }
+        },
+        "api.Execution": {
+            "type": "object",
+            "properties": {
+                "decisions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.Decision"
+                    }
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.GroupTiming"
+                    }
+                },
+                "lateGroups": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "maxLateMillis": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "mismatches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.Mismatch"
+                    }
+                },
+                "omittedDecisions": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "omittedGroups": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "omittedMismatches": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "simulationSeed": {
+                    "description": "SimulationSeed is the seed a simulation ran with, so that it can be repeated.",
+                    "type": "integer",
+                    "example": 42
+                },
+                "startTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00Z"
+                },
+                "stopReason": {
+                    "type": "string",
+                    "example": "durationMillis 3600000 elapsed"
+                },
+                "suspendedMillis": {
+                    "type": "integer",
+                    "example": 4000
+                },
+                "suspensions": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "verifiedGroups": {
+                    "type": "integer",
+                    "example": 12
+                }
+            }
+        },
+        "api.GroupTiming": {
+            "type": "object",
+            "properties": {
+                "actualTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00.203Z"
+                },
+                "branch": {
+                    "description": "Branch is set for groups in the then or else groups of an if, whose Group counts from the start of the branch.",
+                    "type": "string",
+                    "example": "loop 1, command group 3 then"
+                },
+                "finally": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "group": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "late": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "lateMillis": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "loop": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "offsetMillis": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "plannedTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00.2Z"
+                }
+            }
+        },
+        "api.Lock": {
+            "type": "object",
+            "properties": {
+                "acquiredTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00.2Z"
+                },
+                "devices": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "garage"
+                    ]
+                },
+                "policy": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ConflictPolicy"
+                        }
+                    ],
+                    "example": "queue"
+                },
+                "priority": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "program": {
+                    "type": "string",
+                    "example": "doorbell"
+                },
+                "relays": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        }
+                    }
+                },
+                "requestTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00Z"
+                },
+                "runId": {
+                    "type": "string",
+                    "example": "5f0c6a8e-8f2b-4c1e-9b1a-2f8e4d3c7a10"
+                },
+                "state": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.LockState"
+                        }
+                    ],
+                    "example": "held"
+                },
+                "suspendedBy": {
+                    "type": "string",
+                    "example": "program 'doorbell'"
+                }
+            }
+        },
+        "api.LockState": {
+            "type": "string",
+            "enum": [
+                "waiting",
+                "held",
+                "suspended"
+            ],
+            "x-enum-varnames": [
+                "LockWaiting",
+                "LockHeld",
+                "LockSuspended"
+            ]
+        },
+        "api.MillisRange": {
+            "type": "object",
+            "properties": {
+                "max": {
+                    "type": "integer",
+                    "example": 3600000
+                },
+                "min": {
+                    "type": "integer",
+                    "example": 600000
+                }
+            }
+        },
+        "api.Mismatch": {
+            "type": "object",
+            "properties": {
+                "actual": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "attempt": {
+                    "description": "Attempt is 0 for the first read back, and counts retries after that.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "device": {
+                    "type": "string",
+                    "example": "garage"
+                },
+                "expected": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "finally": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "group": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "loop": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "relay": {
+                    "type": "integer",
+                    "example": 7
+                }
+            }
+        },
+        "api.Parameter": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "type": "string",
+                    "example": "1"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "The relay to pulse"
+                },
+                "max": {
+                    "type": "integer",
+                    "example": 8
+                },
+                "min": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "name": {
+                    "type": "string",
+                    "example": "relay"
+                },
+                "type": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ParameterType"
+                        }
+                    ],
+                    "example": "relay"
+                }
+            }
+        },
+        "api.ParameterType": {
+            "type": "string",
+            "enum": [
+                "integer",
+                "relay",
+                "duration",
+                "string"
+            ],
+            "x-enum-varnames": [
+                "ParameterInteger",
+                "ParameterRelay",
+                "ParameterDuration",
+                "ParameterString"
+            ]
+        },
+        "api.PatternDirection": {
+            "type": "string",
+            "enum": [
+                "forward",
+                "backward"
+            ],
+            "x-enum-varnames": [
+                "PatternForward",
+                "PatternBackward"
+            ]
+        },
+        "api.Program": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string",
+                    "example": "modbus.lan:4196"
+                },
+                "commandIntervalMillis": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "commands": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/api.Command"
+                        }
+                    }
+                },
+                "cooldownMillis": {
+                    "type": "integer",
+                    "example": 5000
+                },
+                "debounceMillis": {
+                    "type": "integer",
+                    "example": 2000
+                },
+                "debug": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "durationMillis": {
+                    "type": "integer",
+                    "example": 3600000
+                },
+                "finally": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/api.Command"
+                        }
+                    }
+                },
+                "lastModified": {
+                    "type": "string",
+                    "example": "2025-09-14T12:00:00Z"
+                },
+                "loops": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "maxRunsPerMinute": {
+                    "type": "integer",
+                    "example": 6
+                },
+                "onConflict": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ConflictPolicy"
+                        }
+                    ],
+                    "example": "queue"
+                },
+                "parameters": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.Parameter"
+                    }
+                },
+                "path": {
+                    "type": "string",
+                    "example": "/etc/modbus/doorbell.json"
+                },
+                "priority": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "relays": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.RelayTarget"
+                    }
+                },
+                "restoreState": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "schedule": {
+                    "type": "string",
+                    "example": "0 17 * * *"
+                },
+                "simulate": {
+                    "$ref": "#/definitions/api.Simulation"
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "doorbell"
+                },
+                "until": {
+                    "type": "string",
+                    "example": "23:00"
+                },
+                "values": {
+                    "description": "Values are the parameter values the program was bound to, including defaults.",
+                    "type": "object"
+                },
+                "verify": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "verifyRetries": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "api.RelayCommand": {
+            "type": "string",
+            "enum": [
+                "on",
+                "off",
+                "toggle",
+                "pulse",
+                "wait",
+                "if",
+                "waitFor",
+                "stop",
+                "run",
+                "chase",
+                "bounce",
+                "fill",
+                "alternate",
+                "binaryCounter",
+                "random",
+                "set"
+            ],
+            "x-enum-varnames": [
+                "RelayCommandOn",
+                "RelayCommandOff",
+                "RelayCommandToggle",
+                "RelayCommandPulse",
+                "RelayCommandWait",
+                "RelayCommandIf",
+                "RelayCommandWaitFor",
+                "RelayCommandStop",
+                "RelayCommandRun",
+                "RelayCommandChase",
+                "RelayCommandBounce",
+                "RelayCommandFill",
+                "RelayCommandAlternate",
+                "RelayCommandBinaryCounter",
+                "RelayCommandRandom",
+                "RelayCommandSet"
+            ]
+        },
+        "api.RelayStates": {
+            "type": "object",
+            "additionalProperties": {
+                "type": "object",
+                "additionalProperties": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.RelayTarget": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "type": "string",
+                    "example": "garage"
+                },
+                "relay": {
+                    "type": "string",
+                    "example": "1"
+                }
+            }
+        },
+        "api.SimulatedRelay": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "type": "string",
+                    "example": "garage"
+                },
+                "offMillis": {
+                    "$ref": "#/definitions/api.MillisRange"
+                },
+                "onMillis": {
+                    "$ref": "#/definitions/api.MillisRange"
+                },
+                "relay": {
+                    "type": "string",
+                    "example": "living room"
+                },
+                "windows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.Window"
+                    }
+                }
+            }
+        },
+        "api.Simulation": {
+            "type": "object",
+            "properties": {
+                "relays": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.SimulatedRelay"
+                    }
+                },
+                "seed": {
+                    "description": "Seed makes the simulation repeat exactly, given the same start time. Without one, each run picks its own, and\nrecords it in its execution.",
+                    "type": "integer",
+                    "example": 42
+                },
+                "windows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.Window"
+                    }
+                }
+            }
+        },
+        "api.Window": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string",
+                    "example": "17:30"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "23:00"
+                }
+            }
+        },
+        "modbus.CoilStates": {
+            "type": "object",
+            "properties": {
+                "coils": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "boolean"
+                    }
+                },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "raw": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "boolean"
+                    }
+                }
+            }
+        },
+        "server.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Invalid relay number"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 500
+                }
             }
         },
         "server.ProgramResult": {
             "type": "object",
             "properties": {
+                "calledFrom": {
+                    "type": "string",
+                    "example": "loop 1, command group 2"
+                },
                 "error": {
                     "type": "string",
                     "example": "relay 1 timed out"
                 },
+                "execution": {
+                    "$ref": "#/definitions/api.Execution"
+                },
                 "executionTimeMillis": {
                     "type": "integer",
                     "example": 153
@@ -257,6 +1163,10 @@ const docTemplate = `{
                 "program": {
                     "$ref": "#/definitions/api.Program"
                 },
+                "skipReason": {
+                    "type": "string",
+                    "example": "debounced: triggered again 180 ms after the previous trigger (debounceMillis 2000)"
+                },
                 "slug": {
                     "type": "string",
                     "example": "doorbell"
@@ -265,9 +1175,21 @@ const docTemplate = `{
                     "type": "string",
                     "example": "2025-01-01T12:00:00Z"
                 },
+                "stateAfter": {
+                    "$ref": "#/definitions/api.RelayStates"
+                },
+                "stateBefore": {
+                    "$ref": "#/definitions/api.RelayStates"
+                },
                 "status": {
                     "type": "string",
                     "example": "success"
+                },
+                "subprograms": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/server.ProgramResult"
+                    }
                 }
             }
         },
@@ -279,6 +1201,51 @@ const docTemplate = `{
                 }
             }
         },
+        "server.Run": {
+            "type": "object",
+            "properties": {
+                "endTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:02:00Z"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "5f0c6a8e-8f2b-4c1e-9b1a-2f8e4d3c7a10"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/server.ProgramResult"
+                    }
+                },
+                "schedule": {
+                    "type": "string",
+                    "example": "porch-lights"
+                },
+                "scheduledTime": {
+                    "type": "string",
+                    "example": "2025-01-01T17:00:00Z"
+                },
+                "startTime": {
+                    "type": "string",
+                    "example": "2025-01-01T12:00:00Z"
+                },
+                "state": {
+                    "type": "string",
+                    "example": "running"
+                },
+                "status": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/modbus.CoilStates"
+                    }
+                },
+                "trigger": {
+                    "type": "string",
+                    "example": "schedule"
+                }
+            }
+        },
         "server.RunResponse": {
             "type": "object",
             "properties": {
@@ -295,6 +1262,52 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "server.Schedule": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "solar schedule 'sunset-30m' needs a latitude and longitude"
+                },
+                "lastFireTime": {
+                    "type": "string",
+                    "example": "2024-12-31T17:00:00-05:00"
+                },
+                "lastRunId": {
+                    "type": "string",
+                    "example": "5f0c6a8e-8f2b-4c1e-9b1a-2f8e4d3c7a10"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "porch-lights"
+                },
+                "nextFireTime": {
+                    "type": "string",
+                    "example": "2025-01-01T17:00:00-05:00"
+                },
+                "programs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "porch-lights-on"
+                    ]
+                },
+                "schedule": {
+                    "type": "string",
+                    "example": "0 17 * * *"
+                },
+                "source": {
+                    "type": "string",
+                    "example": "file"
+                },
+                "timeZone": {
+                    "type": "string",
+                    "example": "America/New_York"
+                }
+            }
         }
     }
 }`
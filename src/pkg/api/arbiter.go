@@ -0,0 +1,298 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// ConflictPolicy says what a program does when another program of the same or higher priority is already using one
+// of its relays. Programs of lower priority are always suspended instead.
+type ConflictPolicy string
+
+const (
+	// ConflictQueue waits for conflicting programs to finish, in the order the programs were started. This is the
+	// default.
+	ConflictQueue ConflictPolicy = "queue"
+	// ConflictReject fails immediately if a conflicting program is running or queued.
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictPreempt cancels conflicting programs of the same priority and runs as soon as their finally groups have
+	// finished, ahead of any queued programs.
+	ConflictPreempt ConflictPolicy = "preempt"
+)
+
+type LockState string
+
+const (
+	LockWaiting   LockState = "waiting"
+	LockHeld      LockState = "held"
+	LockSuspended LockState = "suspended"
+)
+
+// Lock is a program's claim on its relays. It is held from before the program connects until its finally groups
+// have run, except while the program is suspended by one of higher priority.
+type Lock struct {
+	Devices      []string         `json:"devices" example:"garage"`
+	Relays       map[string][]int `json:"relays"`
+	Program      string           `json:"program" example:"doorbell"`
+	RunID        string           `json:"runId,omitempty" example:"5f0c6a8e-8f2b-4c1e-9b1a-2f8e4d3c7a10"`
+	Policy       ConflictPolicy   `json:"policy" example:"queue"`
+	Priority     int              `json:"priority" example:"0"`
+	State        LockState        `json:"state" example:"held"`
+	SuspendedBy  string           `json:"suspendedBy,omitempty" example:"program 'doorbell'"`
+	RequestTime  time.Time        `json:"requestTime" example:"2025-01-01T12:00:00Z"`
+	AcquiredTime *time.Time       `json:"acquiredTime,omitempty" example:"2025-01-01T12:00:00.2Z"`
+	keys         *util.Set
+	granted      chan struct{}
+	cancel       context.CancelCauseFunc
+	suspending   bool
+	suspend      chan struct{}
+	resume       chan struct{}
+}
+
+func (l *Lock) String() string {
+	if l.RunID != "" {
+		return fmt.Sprintf("program '%s' (run %s)", l.Program, l.RunID)
+	}
+	return fmt.Sprintf("program '%s'", l.Program)
+}
+
+func (l *Lock) conflictsWith(other *Lock) bool {
+	for _, key := range l.keys.ToArray() {
+		if other.keys.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// BusyError is returned by a program with the reject policy when a conflicting program is running or queued.
+type BusyError struct {
+	Owner string
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("relay is in use by %s", e.Owner)
+}
+
+// PreemptedError is the cancellation cause of a program that was preempted by another. It wraps context.Canceled.
+type PreemptedError struct {
+	By string
+}
+
+func (e *PreemptedError) Error() string {
+	return fmt.Sprintf("preempted by %s", e.By)
+}
+
+func (e *PreemptedError) Unwrap() error {
+	return context.Canceled
+}
+
+// Arbiter decides which program may use each relay, so that concurrent programs don't interleave their commands.
+// Programs conflict only if they use the same relay; programs using different relays on the same device can run
+// at the same time. Relays are identified by device Key and coil address, so two names for the same physical
+// relay conflict.
+//
+// Locks are kept in the order they will be granted: by priority, and then in the order they were requested. A
+// program of higher priority suspends conflicting programs of lower priority, which resume once it has finished.
+type Arbiter struct {
+	locks []*Lock
+	mutex sync.Mutex
+}
+
+func NewArbiter() *Arbiter {
+	return &Arbiter{
+		locks: make([]*Lock, 0),
+	}
+}
+
+// Locks returns the held and suspended locks, followed by the waiting ones in the order they will be granted.
+func (a *Arbiter) Locks() []Lock {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	locks := make([]Lock, 0, len(a.locks))
+	for _, lock := range a.locks {
+		if lock.State != LockWaiting {
+			locks = append(locks, *lock)
+		}
+	}
+	for _, lock := range a.locks {
+		if lock.State == LockWaiting {
+			locks = append(locks, *lock)
+		}
+	}
+	return locks
+}
+
+// acquire claims the program's relays according to its priority and conflict policy, blocking while it is queued.
+// The lock must be released by calling release. cancel is called, with a PreemptedError, if a later program
+// preempts this one.
+func (a *Arbiter) acquire(ctx context.Context, p *Program, cancel context.CancelCauseFunc) (*Lock, error) {
+	relays, err := p.RelayClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{
+		Devices:     p.DeviceNames(ctx),
+		Relays:      relays,
+		Program:     p.Slug,
+		RunID:       runIDFrom(ctx),
+		Policy:      p.GetOnConflict(),
+		Priority:    p.Priority,
+		State:       LockWaiting,
+		RequestTime: time.Now(),
+		keys:        util.NewSet(),
+		granted:     make(chan struct{}),
+		cancel:      cancel,
+		suspend:     make(chan struct{}),
+	}
+	for name, numbers := range relays {
+		device := ResolveDevice(ctx, name)
+		for _, number := range numbers {
+			coil, err := device.CoilAddress(number)
+			if err != nil {
+				return nil, err
+			}
+			lock.keys.Add(fmt.Sprintf("%s/%d", device.Key(), coil))
+		}
+	}
+
+	a.mutex.Lock()
+	conflicts := make([]*Lock, 0)
+	for _, other := range a.locks {
+		if other.Priority >= lock.Priority && lock.conflictsWith(other) {
+			conflicts = append(conflicts, other)
+		}
+	}
+	position := slices.IndexFunc(a.locks, func(l *Lock) bool { return l.Priority < lock.Priority })
+	switch lock.Policy {
+	case ConflictReject:
+		if len(conflicts) > 0 {
+			a.mutex.Unlock()
+			return nil, &BusyError{Owner: conflicts[0].String()}
+		}
+	case ConflictPreempt:
+		for _, other := range conflicts {
+			if other.Priority == lock.Priority && other.State != LockWaiting {
+				util.GetLogger(ctx).Info("Preempting program", "preempted", other.String(), "by", lock.String())
+				other.cancel(&PreemptedError{By: lock.String()})
+			}
+		}
+		position = slices.IndexFunc(a.locks, func(l *Lock) bool {
+			return l.Priority < lock.Priority || (l.Priority == lock.Priority && l.State == LockWaiting)
+		})
+	}
+	if position < 0 {
+		position = len(a.locks)
+	}
+	a.locks = slices.Insert(a.locks, position, lock)
+	a.grant()
+	if lock.State == LockWaiting {
+		util.LogDebug(ctx, "Waiting for relays", "program", p.Slug)
+	}
+	a.mutex.Unlock()
+
+	select {
+	case <-lock.granted:
+		return lock, nil
+	case <-ctx.Done():
+		a.release(lock)
+		return nil, fmt.Errorf("while waiting for relays: %w", context.Cause(ctx))
+	}
+}
+
+// release gives up the lock, or leaves the queue if it was still waiting.
+func (a *Arbiter) release(lock *Lock) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.locks = slices.DeleteFunc(a.locks, func(l *Lock) bool { return l == lock })
+	a.grant()
+}
+
+// suspendRequested returns a channel that is closed when a program of higher priority needs the lock's relays.
+func (a *Arbiter) suspendRequested(lock *Lock) <-chan struct{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return lock.suspend
+}
+
+// suspended hands the lock's relays over to the program that requested them. The returned channel is closed when
+// the lock is held again.
+func (a *Arbiter) suspended(lock *Lock) <-chan struct{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	lock.State = LockSuspended
+	lock.suspending = false
+	lock.resume = make(chan struct{})
+	resume := lock.resume
+	a.grant()
+	return resume
+}
+
+// grant acquires or resumes every lock that doesn't conflict with a lock ahead of it, and asks conflicting programs
+// of lower priority to suspend. The caller must hold the mutex.
+func (a *Arbiter) grant() {
+	for i, lock := range a.locks {
+		if lock.State == LockHeld {
+			continue
+		}
+		if slices.ContainsFunc(a.locks[:i], lock.conflictsWith) {
+			continue
+		}
+		blocked := false
+		for _, later := range a.locks[i+1:] {
+			if later.State != LockHeld || !lock.conflictsWith(later) {
+				continue
+			}
+			blocked = true
+			if later.Priority < lock.Priority && !later.suspending {
+				later.suspending = true
+				later.SuspendedBy = lock.String()
+				close(later.suspend)
+			}
+		}
+		if blocked {
+			continue
+		}
+
+		if lock.State == LockSuspended {
+			lock.State = LockHeld
+			lock.SuspendedBy = ""
+			lock.suspend = make(chan struct{})
+			close(lock.resume)
+		} else {
+			now := time.Now()
+			lock.AcquiredTime = &now
+			lock.State = LockHeld
+			close(lock.granted)
+		}
+	}
+}
+
+type arbiterKey struct{}
+
+type runIDKey struct{}
+
+// WithArbiter makes programs run with ctx take turns on their relays.
+func WithArbiter(ctx context.Context, arbiter *Arbiter) context.Context {
+	return context.WithValue(ctx, arbiterKey{}, arbiter)
+}
+
+func arbiterFrom(ctx context.Context) *Arbiter {
+	arbiter, _ := ctx.Value(arbiterKey{}).(*Arbiter)
+	return arbiter
+}
+
+// WithRunID labels the locks of programs run with ctx, so their owner can be identified.
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+func runIDFrom(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
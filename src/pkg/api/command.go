@@ -12,27 +12,185 @@ const (
 	RelayCommandOn     RelayCommand = "on"
 	RelayCommandOff    RelayCommand = "off"
 	RelayCommandToggle RelayCommand = "toggle"
+	// RelayCommandPulse turns a relay on, then turns it off again after DurationMillis without holding up the rest
+	// of the program.
+	RelayCommandPulse RelayCommand = "pulse"
+	// RelayCommandWait isn't sent to a device. It sets the delay after its command group to DurationMillis, in place
+	// of the program's CommandIntervalMillis.
+	RelayCommandWait RelayCommand = "wait"
+	// RelayCommandIf reads its Condition when its command group comes up, then runs its Then groups if the condition
+	// holds, or its Else groups if it doesn't.
+	RelayCommandIf RelayCommand = "if"
+	// RelayCommandWaitFor holds the program until its Condition holds, or fails it after TimeoutMillis.
+	RelayCommandWaitFor RelayCommand = "waitFor"
+	// RelayCommandStop ends the program cleanly once its command group has been sent. Finally groups still run.
+	RelayCommandStop RelayCommand = "stop"
+	// RelayCommandRun runs the stored Programs, in turn or, if Parallel, all at once, on the calling program's
+	// connections and relays.
+	RelayCommandRun RelayCommand = "run"
 )
 
 type Command struct {
-	Command RelayCommand `json:"command" example:"toggle"`
-	Relay   int          `json:"relay" example:"1"`
+	Command        RelayCommand  `json:"command" example:"toggle"`
+	Relay          RelayRef      `json:"relay,omitzero" swaggertype:"string" example:"1"`
+	Device         string        `json:"device,omitempty" example:"garage"`
+	DurationMillis int           `json:"durationMillis,omitempty" example:"150"`
+	Verify         bool          `json:"verify,omitempty" example:"false"`
+	Condition      *Condition    `json:"condition,omitempty"`
+	Then           [][]Command   `json:"then,omitempty"`
+	Else           [][]Command   `json:"else,omitempty"`
+	TimeoutMillis  int           `json:"timeoutMillis,omitempty" example:"30000"`
+	Programs       []string      `json:"programs,omitempty" example:"all-off"`
+	Parallel       bool          `json:"parallel,omitempty" example:"false"`
+	State          *DesiredState `json:"state,omitempty" swaggertype:"string" example:"0b00010101"`
+	Pattern
 }
 
-func (c *Command) RelayIndex() int {
-	return c.Relay - 1
+// RelayIndex returns the coil address of the command's relay, taking the device's coil base into account.
+func (c *Command) RelayIndex(device *modbus.Device) (int, error) {
+	number, err := c.Relay.Resolve(device)
+	if err != nil {
+		return 0, err
+	}
+	address, err := device.CoilAddress(number)
+	return int(address), err
+}
+
+func (c *Command) Validate(device *modbus.Device) error {
+	if !c.Pattern.isZero() {
+		return fmt.Errorf("%s does not take relays, count, width, direction, steps or seed; they are for patterns", c.Command)
+	}
+	if c.State != nil && c.Command != RelayCommandSet {
+		return fmt.Errorf("%s does not take a state", c.Command)
+	}
+	switch c.Command {
+	case RelayCommandOn, RelayCommandOff, RelayCommandToggle:
+	case RelayCommandPulse:
+		if c.DurationMillis <= 0 {
+			return fmt.Errorf("pulse requires a positive durationMillis")
+		}
+		if c.Verify {
+			return fmt.Errorf("pulse can't be verified, since its relay changes on its own timer")
+		}
+	case RelayCommandWait:
+		if c.DurationMillis < 0 {
+			return fmt.Errorf("wait requires a non-negative durationMillis")
+		}
+		if !c.Relay.IsZero() || c.Device != "" {
+			return fmt.Errorf("wait does not take a relay or device")
+		}
+		if c.Verify {
+			return fmt.Errorf("wait has no relay to verify")
+		}
+		return nil
+	case RelayCommandSet:
+		if c.State == nil {
+			return fmt.Errorf("set requires a state")
+		}
+		if !c.Relay.IsZero() {
+			return fmt.Errorf("set does not take a relay; its state covers the device's relays")
+		}
+		_, err := c.State.States(device)
+		return err
+	case RelayCommandIf, RelayCommandWaitFor, RelayCommandStop, RelayCommandRun:
+		return c.validateFlow()
+	default:
+		return fmt.Errorf("unknown command: %s", c.Command)
+	}
+	_, err := c.RelayIndex(device)
+	return err
 }
 
-func (c *Command) BuildMessage() (modbus.MessageData, error) {
-	relayIndex := c.RelayIndex()
+// validateFlow checks the fields of if, waitFor, stop and run. Conditions, branches and called programs are checked
+// by Program.Validate, since they refer to devices and commands of their own.
+func (c *Command) validateFlow() error {
+	if !c.Relay.IsZero() || c.Device != "" || c.Verify {
+		return fmt.Errorf("%s does not take a relay, device or verify; conditions name their own", c.Command)
+	}
+	if c.Command != RelayCommandRun && (len(c.Programs) > 0 || c.Parallel) {
+		return fmt.Errorf("%s does not take programs or parallel", c.Command)
+	}
+	if c.Command == RelayCommandRun {
+		if len(c.Programs) == 0 {
+			return fmt.Errorf("run requires programs")
+		}
+		if c.Condition != nil || len(c.Then) > 0 || len(c.Else) > 0 || c.TimeoutMillis != 0 {
+			return fmt.Errorf("run takes only programs and parallel")
+		}
+		return nil
+	}
+	if c.Command == RelayCommandStop {
+		if c.Condition != nil {
+			return fmt.Errorf("stop does not take a condition; put it in the branch of an if")
+		}
+		return nil
+	}
+	if c.Condition == nil {
+		return fmt.Errorf("%s requires a condition", c.Command)
+	}
 	switch c.Command {
-	case RelayCommandOn:
-		return modbus.NewWriteSingleCoil(relayIndex, modbus.WriteCommandOn), nil
+	case RelayCommandIf:
+		if len(c.Then) == 0 && len(c.Else) == 0 {
+			return fmt.Errorf("if requires then or else command groups")
+		}
+		if c.TimeoutMillis != 0 {
+			return fmt.Errorf("if does not take a timeoutMillis")
+		}
+	case RelayCommandWaitFor:
+		if len(c.Then) > 0 || len(c.Else) > 0 {
+			return fmt.Errorf("waitFor does not take then or else command groups")
+		}
+		if c.TimeoutMillis < 0 {
+			return fmt.Errorf("waitFor requires a non-negative timeoutMillis")
+		}
+	}
+	return nil
+}
+
+// IsDeviceCommand reports whether the command is sent to a device, as opposed to controlling the program's flow.
+func (c *Command) IsDeviceCommand() bool {
+	switch c.Command {
+	case RelayCommandOn, RelayCommandOff, RelayCommandToggle, RelayCommandPulse, RelayCommandSet:
+		return true
+	default:
+		return false
+	}
+}
+
+// runsAlone reports whether the command must be alone in its command group. if, waitFor and run take as long as they
+// take, so the groups after them are scheduled from when they finish.
+func (c *Command) runsAlone() bool {
+	switch c.Command {
+	case RelayCommandIf, RelayCommandWaitFor, RelayCommandRun:
+		return true
+	default:
+		return false
+	}
+}
+
+// BuildMessage translates the command into a Modbus message. On and off are logical states; for relays the device
+// marks as inverted, "on" de-energizes the coil.
+func (c *Command) BuildMessage(device *modbus.Device) (modbus.MessageData, error) {
+	relayIndex, err := c.RelayIndex(device)
+	if err != nil {
+		return nil, err
+	}
+	inverted := device.IsInverted(device.RelayNumberForCoil(uint16(relayIndex)))
+	switch c.Command {
+	case RelayCommandOn, RelayCommandPulse:
+		return modbus.NewWriteSingleCoil(relayIndex, coilCommand(true, inverted)), nil
 	case RelayCommandOff:
-		return modbus.NewWriteSingleCoil(relayIndex, modbus.WriteCommandOff), nil
+		return modbus.NewWriteSingleCoil(relayIndex, coilCommand(false, inverted)), nil
 	case RelayCommandToggle:
 		return modbus.NewWriteSingleCoil(relayIndex, modbus.WriteCommandToggle), nil
 	default:
 		return nil, fmt.Errorf("unknown command: %s", c.Command)
 	}
 }
+
+func coilCommand(on bool, inverted bool) modbus.WriteCommand {
+	if on != inverted {
+		return modbus.WriteCommandOn
+	}
+	return modbus.WriteCommandOff
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// WaitForPollInterval is how often waitFor reads its condition.
+const WaitForPollInterval = 100 * time.Millisecond
+
+type ConditionState string
+
+const (
+	ConditionOn  ConditionState = "on"
+	ConditionOff ConditionState = "off"
+)
+
+// Condition is the state of a relay, read with Read Coils, or of a discrete input, read with Read Discrete Inputs, on
+// the program's device or on Device. Relay states are logical, so "on" takes the device's polarity into account.
+type Condition struct {
+	Relay  RelayRef       `json:"relay,omitzero" swaggertype:"string" example:"3"`
+	Input  int            `json:"input,omitempty" example:"1"`
+	Device string         `json:"device,omitempty" example:"garage"`
+	Is     ConditionState `json:"is" example:"on"`
+}
+
+func (c *Condition) String() string {
+	return fmt.Sprintf("%s is %s", c.subject(), c.Is)
+}
+
+// subject names the relay or input the condition reads, e.g. "garage input 1".
+func (c *Condition) subject() string {
+	subject := "relay " + c.Relay.String()
+	if c.Input > 0 {
+		subject = "input " + strconv.Itoa(c.Input)
+	}
+	if c.Device != "" {
+		subject = c.Device + " " + subject
+	}
+	return subject
+}
+
+func (c *Condition) Validate(device *modbus.Device) error {
+	switch c.Is {
+	case ConditionOn, ConditionOff:
+	default:
+		return fmt.Errorf("condition requires 'is' to be 'on' or 'off', not '%s'", c.Is)
+	}
+	if c.Input < 0 {
+		return fmt.Errorf("condition input must be a positive number")
+	}
+	if c.Relay.IsZero() == (c.Input == 0) {
+		return fmt.Errorf("condition requires either a relay or an input")
+	}
+	if c.Input > 0 {
+		return nil
+	}
+	number, err := c.Relay.Resolve(device)
+	if err == nil {
+		_, err = device.CoilAddress(number)
+	}
+	return err
+}
+
+// ConditionDevice returns the device name or address a condition is read from.
+func (p *Program) ConditionDevice(c *Condition) string {
+	if c.Device != "" {
+		return c.Device
+	}
+	return p.Address
+}
+
+// Decision records how the condition of an if or waitFor was found, and what the program did about it.
+type Decision struct {
+	Loop      int          `json:"loop" example:"1"`
+	Group     int          `json:"group" example:"3"`
+	Finally   bool         `json:"finally,omitempty" example:"false"`
+	Branch    string       `json:"branch,omitempty" example:"loop 1, command group 3 then"`
+	Command   RelayCommand `json:"command" example:"if"`
+	Condition string       `json:"condition" example:"relay 3 is on"`
+	Time      time.Time    `json:"time" example:"2025-01-01T12:00:00.4Z"`
+	// Result is whether the condition held: for if, when it was read, and for waitFor, when the wait ended.
+	Result bool `json:"result" example:"true"`
+	// Taken is the branch an if ran: "then" or "else".
+	Taken        string `json:"taken,omitempty" example:"then"`
+	WaitedMillis int64  `json:"waitedMillis,omitempty" example:"1200"`
+	TimedOut     bool   `json:"timedOut,omitempty" example:"false"`
+}
+
+// errStop ends a program early, but cleanly: it has reached its deadline, or a stop command. It is passed up
+// unwrapped through nested branches, and runCommands and runFinally turn it back into success.
+var errStop = errors.New("stopped")
+
+// check reads a condition's relay or input, and reports whether the condition holds.
+func (s *session) check(ctx context.Context, p *Program, c *Condition) (bool, error) {
+	name := p.ConditionDevice(c)
+	device := s.device(ctx, name)
+	var on bool
+	if c.Input > 0 {
+		response, err := s.exchange(ctx, name, modbus.NewReadDiscreteInputs(uint16(c.Input-1), 1))
+		if err != nil {
+			return false, fmt.Errorf("failed to read input %d on %s: %w", c.Input, device.DisplayName(), err)
+		}
+		on = response.(*modbus.CoilStates).Coils[strconv.Itoa(c.Input)]
+	} else {
+		number, err := c.Relay.Resolve(device)
+		if err != nil {
+			return false, err
+		}
+		states, err := s.snapshot(ctx, map[string][]int{name: {number}})
+		if err != nil {
+			return false, err
+		}
+		on = states[name][number]
+	}
+	return on == (c.Is == ConditionOn), nil
+}
+
+// runIf reads the condition of an if, and runs its then or else groups to completion, on a timeline of their own.
+func (p *Program) runIf(ctx context.Context, session *session, execution *Execution, cmd Command, timing GroupTiming, deadline *deadline, scope groupScope) error {
+	where := scope.where(timing.Loop, timing.Group)
+	holds, err := session.check(ctx, p, cmd.Condition)
+	if err != nil {
+		return fmt.Errorf("failure in %s: %w", where, err)
+	}
+	taken, groups := "then", cmd.Then
+	if !holds {
+		taken, groups = "else", cmd.Else
+	}
+	decision := newDecision(cmd, timing)
+	decision.Result = holds
+	decision.Taken = taken
+	execution.recordDecision(decision)
+	util.LogDebug(ctx, "Evaluated condition", "condition", cmd.Condition, "holds", holds, "taken", taken, "groupCount", len(groups))
+
+	branch := groupScope{finally: scope.finally, branch: where + " " + taken, loop: timing.Loop}
+	return p.runGroups(ctx, session, execution, groups, 1, deadline, branch)
+}
+
+// waitFor reads the condition of a waitFor every WaitForPollInterval until it holds. It fails the program if the
+// timeout passes first, and stops it cleanly if its deadline does. Time spent suspended doesn't count towards the
+// timeout, or the time waited.
+func (p *Program) waitFor(ctx context.Context, session *session, execution *Execution, cmd Command, timing GroupTiming, deadline *deadline, scope groupScope) error {
+	where := scope.where(timing.Loop, timing.Group)
+	start := time.Now()
+	var timeout time.Time
+	if cmd.TimeoutMillis > 0 {
+		timeout = start.Add(time.Duration(cmd.TimeoutMillis) * time.Millisecond)
+	}
+	decision := newDecision(cmd, timing)
+	for {
+		holds, err := session.check(ctx, p, cmd.Condition)
+		if err != nil {
+			return fmt.Errorf("failure in %s: %w", where, err)
+		}
+		now := time.Now()
+		decision.Result = holds
+		decision.WaitedMillis = now.Sub(start).Milliseconds()
+		if holds {
+			execution.recordDecision(decision)
+			return nil
+		}
+		if !timeout.IsZero() && !now.Before(timeout) {
+			decision.TimedOut = true
+			execution.recordDecision(decision)
+			return fmt.Errorf("failure in %s: timed out after %dms waiting for %s to be %s",
+				where, cmd.TimeoutMillis, cmd.Condition.subject(), cmd.Condition.Is)
+		}
+		if deadline != nil && !now.Before(deadline.time) {
+			execution.recordDecision(decision)
+			execution.stop(deadline.reason)
+			return errStop
+		}
+
+		wait := WaitForPollInterval
+		if !timeout.IsZero() {
+			wait = min(wait, timeout.Sub(now))
+		}
+		if deadline != nil {
+			wait = min(wait, deadline.time.Sub(now))
+		}
+		suspended, err := session.sleep(ctx, execution, wait, !scope.finally)
+		if err != nil {
+			execution.recordDecision(decision)
+			return fmt.Errorf("stopped in %s, waiting for %s to be %s: %w", where, cmd.Condition.subject(), cmd.Condition.Is, err)
+		}
+		start = start.Add(suspended)
+		if !timeout.IsZero() {
+			timeout = timeout.Add(suspended)
+		}
+	}
+}
+
+func newDecision(cmd Command, timing GroupTiming) Decision {
+	return Decision{
+		Loop:      timing.Loop,
+		Group:     timing.Group,
+		Finally:   timing.Finally,
+		Branch:    timing.Branch,
+		Command:   cmd.Command,
+		Condition: cmd.Condition.String(),
+		Time:      time.Now(),
+	}
+}
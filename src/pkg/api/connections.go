@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// Connections shares device connections between programs that run at the same time. Requests on a shared
+// connection are serialized, so programs using different relays on the same device don't interfere. A connection
+// is opened when the first program needs it and closed when the last one has finished with it.
+type Connections struct {
+	conns map[string]*sharedConn
+	mutex sync.Mutex
+}
+
+type sharedConn struct {
+	conn  *modbus.Conn
+	users int
+	mutex sync.Mutex
+}
+
+func NewConnections() *Connections {
+	return &Connections{
+		conns: make(map[string]*sharedConn),
+	}
+}
+
+// acquire returns a connection to the device, registering the caller as one of its users. Each successful call
+// must be matched by a call to release.
+func (c *Connections) acquire(ctx context.Context, device *modbus.Device) (*modbus.Conn, error) {
+	key := device.Key()
+	c.mutex.Lock()
+	shared, exists := c.conns[key]
+	if !exists {
+		shared = &sharedConn{}
+		c.conns[key] = shared
+	}
+	shared.users++
+	c.mutex.Unlock()
+
+	conn, err := shared.current(ctx, device)
+	if err != nil {
+		c.release(key)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// reconnect returns a working connection to a device the caller has already acquired, replacing it if it's broken.
+func (c *Connections) reconnect(ctx context.Context, device *modbus.Device) (*modbus.Conn, error) {
+	c.mutex.Lock()
+	shared := c.conns[device.Key()]
+	c.mutex.Unlock()
+	return shared.current(ctx, device)
+}
+
+// release unregisters a user of a connection, and closes it if that was the last one. The connection is closed
+// after it has left the map, so that a slow connect to one device doesn't hold up the others.
+func (c *Connections) release(key string) {
+	c.mutex.Lock()
+	shared := c.conns[key]
+	shared.users--
+	if shared.users > 0 {
+		c.mutex.Unlock()
+		return
+	}
+	delete(c.conns, key)
+	c.mutex.Unlock()
+
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+	if shared.conn != nil {
+		util.CloseQuietly(shared.conn)
+	}
+}
+
+// current returns the connection, opening a new one if there is none yet or the existing one is broken.
+func (s *sharedConn) current(ctx context.Context, device *modbus.Device) (*modbus.Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil && !s.conn.Broken() {
+		return s.conn, nil
+	}
+	if s.conn != nil {
+		util.LogDebug(ctx, "Reconnecting after interrupted exchange", "address", device.Address)
+		util.CloseQuietly(s.conn)
+		s.conn = nil
+	}
+	conn, err := modbus.Connect(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+type connectionsKey struct{}
+
+// WithConnections makes programs run with ctx share their device connections.
+func WithConnections(ctx context.Context, connections *Connections) context.Context {
+	return context.WithValue(ctx, connectionsKey{}, connections)
+}
+
+func connectionsFrom(ctx context.Context) *Connections {
+	connections, _ := ctx.Value(connectionsKey{}).(*Connections)
+	return connections
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+)
+
+// DeviceResolver looks up named devices. Anything that isn't a known device name is treated as a raw address.
+type DeviceResolver interface {
+	ResolveDevice(ctx context.Context, nameOrAddress string) *modbus.Device
+}
+
+// Devices is a set of named devices, as read from a devices file.
+type Devices map[string]*modbus.Device
+
+func (d Devices) ResolveDevice(_ context.Context, nameOrAddress string) *modbus.Device {
+	if device, exists := d[nameOrAddress]; exists {
+		return device
+	}
+	return modbus.NewDevice(nameOrAddress)
+}
+
+type deviceResolverKey struct{}
+
+func WithDeviceResolver(ctx context.Context, resolver DeviceResolver) context.Context {
+	return context.WithValue(ctx, deviceResolverKey{}, resolver)
+}
+
+// ResolveDevice returns the device with the given name, or a device with default settings if nameOrAddress is not
+// a known device name.
+func ResolveDevice(ctx context.Context, nameOrAddress string) *modbus.Device {
+	if resolver, ok := ctx.Value(deviceResolverKey{}).(DeviceResolver); ok {
+		return resolver.ResolveDevice(ctx, nameOrAddress)
+	}
+	return modbus.NewDevice(nameOrAddress)
+}
+
+func ParseDevicesFromFile(path string) (Devices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDevices(data)
+}
+
+func ParseDevices(data []byte) (Devices, error) {
+	var devices Devices
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON devices: %w", err)
+	}
+
+	for name, device := range devices {
+		if device == nil {
+			return nil, fmt.Errorf("device '%s' is empty", name)
+		}
+		if err := device.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid device '%s': %w", name, err)
+		}
+		device.Name = name
+	}
+	return devices, nil
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LateToleranceMillis is how far behind schedule a command group may start before it is reported as late.
+const LateToleranceMillis = 10
+
+// MaxRecordedGroups is how many group timings an execution keeps. Programs that loop for a long time keep only the
+// most recent ones.
+const MaxRecordedGroups = 1000
+
+// FinallyTimeout bounds how long a program's finally groups may take, since they can't be cancelled.
+const FinallyTimeout = 30 * time.Second
+
+// Execution records what happened during a program run. It is safe to read while the program is running.
+type Execution struct {
+	StartTime         *time.Time    `json:"startTime,omitempty" example:"2025-01-01T12:00:00Z"`
+	Groups            []GroupTiming `json:"groups"`
+	OmittedGroups     int           `json:"omittedGroups,omitempty" example:"0"`
+	LateGroups        int           `json:"lateGroups" example:"0"`
+	MaxLateMillis     int64         `json:"maxLateMillis" example:"0"`
+	Suspensions       int           `json:"suspensions,omitempty" example:"1"`
+	SuspendedMillis   int64         `json:"suspendedMillis,omitempty" example:"4000"`
+	StopReason        string        `json:"stopReason,omitempty" example:"durationMillis 3600000 elapsed"`
+	VerifiedGroups    int           `json:"verifiedGroups,omitempty" example:"12"`
+	Mismatches        []Mismatch    `json:"mismatches,omitempty"`
+	OmittedMismatches int           `json:"omittedMismatches,omitempty" example:"0"`
+	Decisions         []Decision    `json:"decisions,omitempty"`
+	OmittedDecisions  int           `json:"omittedDecisions,omitempty" example:"0"`
+	// SimulationSeed is the seed a simulation ran with, so that it can be repeated.
+	SimulationSeed int64 `json:"simulationSeed,omitempty" example:"42"`
+	subprograms    []*Subprogram
+	stateBefore    RelayStates
+	stateAfter     RelayStates
+	mutex          sync.Mutex
+}
+
+// GroupTiming compares when a command group was scheduled to start with when it actually started.
+type GroupTiming struct {
+	Loop         int       `json:"loop" example:"1"`
+	Group        int       `json:"group" example:"2"`
+	PlannedTime  time.Time `json:"plannedTime" example:"2025-01-01T12:00:00.2Z"`
+	ActualTime   time.Time `json:"actualTime" example:"2025-01-01T12:00:00.203Z"`
+	OffsetMillis int64     `json:"offsetMillis" example:"200"`
+	LateMillis   int64     `json:"lateMillis" example:"3"`
+	Late         bool      `json:"late,omitempty" example:"false"`
+	Finally      bool      `json:"finally,omitempty" example:"false"`
+	// Branch is set for groups in the then or else groups of an if, whose Group counts from the start of the branch.
+	Branch string `json:"branch,omitempty" example:"loop 1, command group 3 then"`
+}
+
+func NewExecution() *Execution {
+	return &Execution{
+		Groups: make([]GroupTiming, 0),
+	}
+}
+
+func (e *Execution) start(startTime time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.StartTime = &startTime
+}
+
+func (e *Execution) recordGroup(timing GroupTiming) GroupTiming {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	timing.LateMillis = timing.ActualTime.Sub(timing.PlannedTime).Milliseconds()
+	timing.Late = timing.LateMillis > LateToleranceMillis
+	if len(e.Groups) == MaxRecordedGroups {
+		e.Groups = slices.Delete(e.Groups, 0, 1)
+		e.OmittedGroups++
+	}
+	e.Groups = append(e.Groups, timing)
+	if timing.Late {
+		e.LateGroups++
+		e.MaxLateMillis = max(e.MaxLateMillis, timing.LateMillis)
+	}
+	return timing
+}
+
+// recordSuspension notes that the program was suspended by one of higher priority.
+func (e *Execution) recordSuspension(d time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.Suspensions++
+	e.SuspendedMillis += d.Milliseconds()
+}
+
+// stop notes why the program stopped looping early.
+func (e *Execution) stop(reason string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.StopReason = reason
+}
+
+// recordVerification notes that a command group was read back, and any relays that didn't match.
+func (e *Execution) recordVerification(mismatches []Mismatch) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(mismatches) == 0 {
+		e.VerifiedGroups++
+	}
+	for _, mismatch := range mismatches {
+		if len(e.Mismatches) == MaxRecordedGroups {
+			e.Mismatches = slices.Delete(e.Mismatches, 0, 1)
+			e.OmittedMismatches++
+		}
+		e.Mismatches = append(e.Mismatches, mismatch)
+	}
+}
+
+func (e *Execution) recordSimulationSeed(seed int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.SimulationSeed = seed
+}
+
+// recordDecision notes how an if or waitFor went.
+func (e *Execution) recordDecision(decision Decision) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.Decisions) == MaxRecordedGroups {
+		e.Decisions = slices.Delete(e.Decisions, 0, 1)
+		e.OmittedDecisions++
+	}
+	e.Decisions = append(e.Decisions, decision)
+}
+
+func (e *Execution) recordStateBefore(states RelayStates) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stateBefore = states
+}
+
+func (e *Execution) recordStateAfter(states RelayStates) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stateAfter = states
+}
+
+// RelayStates returns the states of a restoreState program's relays before it ran and after they were restored. Either
+// is nil if it couldn't be read.
+func (e *Execution) RelayStates() (before RelayStates, after RelayStates) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.stateBefore, e.stateAfter
+}
+
+func (e *Execution) MarshalJSON() ([]byte, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	type execution Execution
+	return json.Marshal((*execution)(e))
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// fakeDevice is a Modbus TCP relay board for tests, supporting Read Coils, Write Single Coil and Write Multiple
+// Coils.
+type fakeDevice struct {
+	address string
+	coils   []bool
+	mutex   sync.Mutex
+}
+
+func newFakeDevice(t *testing.T, coilCount int) *fakeDevice {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	d := &fakeDevice{address: listener.Addr().String(), coils: make([]bool, coilCount)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDevice) coil(number int) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.coils[number-1]
+}
+
+func (d *fakeDevice) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		response := d.handle(pdu)
+		frame := append(header[:4:4], 0, 0, header[6])
+		binary.BigEndian.PutUint16(frame[4:], uint16(len(response)+1))
+		if _, err := conn.Write(append(frame, response...)); err != nil {
+			return
+		}
+	}
+}
+
+func (d *fakeDevice) handle(pdu []byte) []byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	start := int(binary.BigEndian.Uint16(pdu[1:]))
+	switch pdu[0] {
+	case 0x01:
+		quantity := int(binary.BigEndian.Uint16(pdu[3:]))
+		if start+quantity > len(d.coils) {
+			return []byte{pdu[0] | 0x80, 2}
+		}
+		response := make([]byte, 2+(quantity+7)/8)
+		response[0], response[1] = pdu[0], byte(len(response)-2)
+		for i := range quantity {
+			if d.coils[start+i] {
+				response[2+i/8] |= 1 << (i % 8)
+			}
+		}
+		return response
+	case 0x05:
+		if start >= len(d.coils) {
+			return []byte{pdu[0] | 0x80, 2}
+		}
+		d.coils[start] = binary.BigEndian.Uint16(pdu[3:]) == 0xFF00
+		return pdu
+	case 0x0F:
+		quantity := int(binary.BigEndian.Uint16(pdu[3:]))
+		for i := range quantity {
+			d.coils[start+i] = pdu[6+i/8]&(1<<(i%8)) != 0
+		}
+		return pdu[:5]
+	default:
+		return []byte{pdu[0] | 0x80, 1}
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ParameterType string
+
+const (
+	// ParameterInteger is a whole number, such as a loop count.
+	ParameterInteger ParameterType = "integer"
+	// ParameterRelay is a relay number or name. Min and Max apply to numbers.
+	ParameterRelay ParameterType = "relay"
+	// ParameterDuration is a number of milliseconds, which can also be given as a duration like "1.5s".
+	ParameterDuration ParameterType = "duration"
+	ParameterString   ParameterType = "string"
+)
+
+// reservedParameterNames are the query parameters of /run, which can't also be program parameters.
+var reservedParameterNames = []string{"program", "async", "debug", "ignoreBody"}
+
+var (
+	parameterNameRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	parameterRefRegexp     = regexp.MustCompile(`\$\{([^}]*)\}`)
+	wholeParameterRefRegex = regexp.MustCompile(`^\$\{([^}]*)\}$`)
+)
+
+// Parameter is a value that a program refers to as "${name}", and that is set each time the program runs. A string
+// that is nothing but a reference is replaced by the value itself, so "${relay}" can stand for a relay number;
+// references within longer strings are replaced by the value's text. A parameter without a default is required.
+type Parameter struct {
+	Name        string        `json:"name" example:"relay"`
+	Type        ParameterType `json:"type" example:"relay"`
+	Description string        `json:"description,omitempty" example:"The relay to pulse"`
+	Default     any           `json:"default,omitempty" swaggertype:"string" example:"1"`
+	Min         *int          `json:"min,omitempty" example:"1"`
+	Max         *int          `json:"max,omitempty" example:"8"`
+}
+
+func (p *Parameter) Validate() error {
+	if !parameterNameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid parameter name '%s': use letters, digits and underscores", p.Name)
+	}
+	if slices.Contains(reservedParameterNames, p.Name) {
+		return fmt.Errorf("parameter name '%s' is reserved", p.Name)
+	}
+	switch p.Type {
+	case ParameterInteger, ParameterRelay, ParameterDuration:
+	case ParameterString:
+		if p.Min != nil || p.Max != nil {
+			return fmt.Errorf("parameter '%s' is a string, and can't have a min or max", p.Name)
+		}
+	default:
+		return fmt.Errorf("parameter '%s' has unknown type '%s'; expected integer, relay, duration or string", p.Name, p.Type)
+	}
+	if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+		return fmt.Errorf("parameter '%s' has a min greater than its max", p.Name)
+	}
+	if p.Default != nil {
+		if _, err := p.Parse(p.defaultText()); err != nil {
+			return fmt.Errorf("invalid default: %w", err)
+		}
+	}
+	return nil
+}
+
+// Parse converts a value, given as text as in a query string or on the command line, to the parameter's type, and
+// checks its range. Durations become milliseconds.
+func (p *Parameter) Parse(value string) (any, error) {
+	switch p.Type {
+	case ParameterString:
+		return value, nil
+	case ParameterRelay:
+		if number, err := strconv.Atoi(value); err == nil {
+			return number, p.checkRange(number)
+		}
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("parameter '%s' must be a relay number or name", p.Name)
+		}
+		return value, nil
+	case ParameterDuration:
+		millis, err := strconv.Atoi(value)
+		if err != nil {
+			duration, durationErr := time.ParseDuration(value)
+			if durationErr != nil {
+				return nil, fmt.Errorf("parameter '%s' must be milliseconds or a duration like 1.5s, not '%s'", p.Name, value)
+			}
+			millis = int(duration.Milliseconds())
+		}
+		if millis < 0 {
+			return nil, fmt.Errorf("parameter '%s' must not be negative", p.Name)
+		}
+		return millis, p.checkRange(millis)
+	default:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter '%s' must be an integer, not '%s'", p.Name, value)
+		}
+		return number, p.checkRange(number)
+	}
+}
+
+func (p *Parameter) checkRange(value int) error {
+	switch {
+	case p.Min != nil && p.Max != nil && (value < *p.Min || value > *p.Max):
+		return fmt.Errorf("parameter '%s' must be from %d to %d, not %d", p.Name, *p.Min, *p.Max, value)
+	case p.Min != nil && value < *p.Min:
+		return fmt.Errorf("parameter '%s' must be at least %d, not %d", p.Name, *p.Min, value)
+	case p.Max != nil && value > *p.Max:
+		return fmt.Errorf("parameter '%s' must be at most %d, not %d", p.Name, *p.Max, value)
+	}
+	return nil
+}
+
+// defaultText returns the default as text, so it is parsed like any other value.
+func (p *Parameter) defaultText() string {
+	switch value := p.Default.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// zero stands in for a required parameter that hasn't been given, so the rest of the program can still be parsed.
+func (p *Parameter) zero() any {
+	if p.Type == ParameterString {
+		return ""
+	}
+	return 0
+}
+
+// parseTemplate parses a program that declares parameters, binding them to their defaults.
+func parseTemplate(programBytes []byte, parameters []Parameter) (*Program, error) {
+	names := make([]string, 0, len(parameters))
+	for _, parameter := range parameters {
+		if err := parameter.Validate(); err != nil {
+			return nil, err
+		}
+		if slices.Contains(names, parameter.Name) {
+			return nil, fmt.Errorf("parameter '%s' is declared more than once", parameter.Name)
+		}
+		names = append(names, parameter.Name)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(programBytes))
+	decoder.UseNumber()
+	var template map[string]any
+	if err := decoder.Decode(&template); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON program: %w", err)
+	}
+	body := maps.Clone(template)
+	delete(body, "parameters")
+	for _, name := range parameterRefs(body) {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown parameter '${%s}'", name)
+		}
+	}
+	return bind(template, parameters, nil)
+}
+
+// Bind returns a copy of the program with its parameters set to values, given as text. Parameters without a value
+// take their defaults, and values for parameters the program doesn't declare are ignored. Programs without
+// parameters are returned as they are.
+func (p *Program) Bind(values map[string]string) (*Program, error) {
+	if p.template == nil {
+		return p, nil
+	}
+	bound, err := bind(p.template, p.Parameters, values)
+	if err != nil {
+		return nil, err
+	}
+	bound.Slug, bound.Path, bound.LastModified = p.Slug, p.Path, p.LastModified
+	return bound, nil
+}
+
+func bind(template map[string]any, parameters []Parameter, values map[string]string) (*Program, error) {
+	resolved := make(map[string]any, len(parameters))
+	bound := make(map[string]any, len(parameters))
+	missing := make([]string, 0)
+	for _, parameter := range parameters {
+		text, given := values[parameter.Name]
+		if !given && parameter.Default != nil {
+			text, given = parameter.defaultText(), true
+		}
+		if !given {
+			missing = append(missing, parameter.Name)
+			resolved[parameter.Name] = parameter.zero()
+			continue
+		}
+		value, err := parameter.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		resolved[parameter.Name] = value
+		bound[parameter.Name] = value
+	}
+
+	body := maps.Clone(template)
+	delete(body, "parameters")
+	substituted := substitute(body, resolved).(map[string]any)
+	substituted["parameters"] = template["parameters"]
+	data, err := json.Marshal(substituted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply parameters: %w", err)
+	}
+	var program Program
+	if err := json.Unmarshal(data, &program); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON program with its parameters: %w", err)
+	}
+	if program.Address == "" {
+		return nil, fmt.Errorf("missing required field: address")
+	}
+	if err := program.expandPatterns(); err != nil {
+		return nil, err
+	}
+	program.template = template
+	program.Values = bound
+	program.missing = missing
+	return &program, nil
+}
+
+// substitute replaces parameter references in the strings of a parsed JSON value.
+func substitute(node any, values map[string]any) any {
+	switch value := node.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(value))
+		for key, child := range value {
+			result[key] = substitute(child, values)
+		}
+		return result
+	case []any:
+		result := make([]any, len(value))
+		for i, child := range value {
+			result[i] = substitute(child, values)
+		}
+		return result
+	case string:
+		if match := wholeParameterRefRegex.FindStringSubmatch(value); match != nil {
+			return values[match[1]]
+		}
+		return parameterRefRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+			return fmt.Sprint(values[parameterRefRegexp.FindStringSubmatch(ref)[1]])
+		})
+	default:
+		return value
+	}
+}
+
+// parameterRefs returns the names of the parameters referred to in a parsed JSON value.
+func parameterRefs(node any) []string {
+	names := make([]string, 0)
+	switch value := node.(type) {
+	case map[string]any:
+		for _, child := range value {
+			names = append(names, parameterRefs(child)...)
+		}
+	case []any:
+		for _, child := range value {
+			names = append(names, parameterRefs(child)...)
+		}
+	case string:
+		for _, match := range parameterRefRegexp.FindAllStringSubmatch(value, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+const (
+	// RelayCommandChase lights Width neighbouring relays at a time, moving one relay per group and wrapping around.
+	RelayCommandChase RelayCommand = "chase"
+	// RelayCommandBounce lights Width neighbouring relays at a time, moving to one end and back.
+	RelayCommandBounce RelayCommand = "bounce"
+	// RelayCommandFill turns the relays on one per group until all are on, then off again in the same order.
+	RelayCommandFill RelayCommand = "fill"
+	// RelayCommandAlternate switches between the odd-numbered and the even-numbered relays.
+	RelayCommandAlternate RelayCommand = "alternate"
+	// RelayCommandBinaryCounter counts in binary, with the first relay as the lowest bit.
+	RelayCommandBinaryCounter RelayCommand = "binaryCounter"
+	// RelayCommandRandom turns each relay on or off at random in each group. The same Seed gives the same sequence.
+	RelayCommandRandom RelayCommand = "random"
+)
+
+// MaxPatternSteps limits the command groups a pattern can generate.
+const MaxPatternSteps = 4096
+
+type PatternDirection string
+
+const (
+	PatternForward  PatternDirection = "forward"
+	PatternBackward PatternDirection = "backward"
+)
+
+// Pattern holds the fields of the pattern commands, which generate command groups when the program is parsed. Each
+// generated group is one step of the pattern. The first sets every relay of the pattern, and the rest change only
+// the relays that differ from the step before, so that looping the program runs the pattern continuously.
+type Pattern struct {
+	// Relays are the relays of the pattern, in order. Count is a shorthand for relays 1 to Count.
+	Relays    []RelayRef       `json:"relays,omitempty" swaggertype:"array,string" example:"1,2,3,4"`
+	Count     int              `json:"count,omitempty" example:"8"`
+	Width     int              `json:"width,omitempty" example:"3"`
+	Direction PatternDirection `json:"direction,omitempty" example:"forward"`
+	// Steps is the number of groups for random, which defaults to one per relay, and binaryCounter, which defaults to
+	// counting through every combination.
+	Steps int   `json:"steps,omitempty" example:"16"`
+	Seed  int64 `json:"seed,omitempty" example:"42"`
+}
+
+func (p *Pattern) isZero() bool {
+	return len(p.Relays) == 0 && p.Count == 0 && p.Width == 0 && p.Direction == "" && p.Steps == 0 && p.Seed == 0
+}
+
+// IsPattern reports whether the command generates command groups, rather than being run itself.
+func (c *Command) IsPattern() bool {
+	switch c.Command {
+	case RelayCommandChase, RelayCommandBounce, RelayCommandFill, RelayCommandAlternate, RelayCommandBinaryCounter, RelayCommandRandom:
+		return true
+	default:
+		return false
+	}
+}
+
+// expand generates the command groups of a pattern command.
+func (c *Command) expand() ([][]Command, error) {
+	relays, err := c.patternRelays()
+	if err != nil {
+		return nil, err
+	}
+	steps, err := c.patternSteps(len(relays))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([][]Command, len(steps))
+	for i, lit := range steps {
+		group := make([]Command, 0, len(relays))
+		for k, relay := range relays {
+			if i > 0 && lit[k] == steps[i-1][k] {
+				continue
+			}
+			command := RelayCommandOff
+			if lit[k] {
+				command = RelayCommandOn
+			}
+			group = append(group, Command{Command: command, Relay: relay, Device: c.Device, Verify: c.Verify})
+		}
+		groups[i] = group
+	}
+	return groups, nil
+}
+
+func (c *Command) patternRelays() ([]RelayRef, error) {
+	if (len(c.Relays) == 0) == (c.Count == 0) {
+		return nil, fmt.Errorf("%s requires either relays or a count", c.Command)
+	}
+	relays := slices.Clone(c.Relays)
+	if c.Count < 0 {
+		return nil, fmt.Errorf("%s requires a positive count", c.Command)
+	}
+	for number := 1; number <= c.Count; number++ {
+		relays = append(relays, RelayNumber(number))
+	}
+	switch c.Direction {
+	case "", PatternForward:
+	case PatternBackward:
+		slices.Reverse(relays)
+	default:
+		return nil, fmt.Errorf("%s direction must be 'forward' or 'backward', not '%s'", c.Command, c.Direction)
+	}
+	return relays, nil
+}
+
+// patternSteps returns which of n relays are lit in each step of the pattern.
+func (c *Command) patternSteps(n int) ([][]bool, error) {
+	width := c.Width
+	if width == 0 {
+		width = 1
+	}
+	if c.Width != 0 && c.Command != RelayCommandChase && c.Command != RelayCommandBounce {
+		return nil, fmt.Errorf("%s does not take a width", c.Command)
+	}
+	if width < 0 || width > n {
+		return nil, fmt.Errorf("%s width must be from 1 to the number of relays, %d", c.Command, n)
+	}
+	if c.Steps != 0 && c.Command != RelayCommandRandom && c.Command != RelayCommandBinaryCounter {
+		return nil, fmt.Errorf("%s does not take steps", c.Command)
+	}
+	if c.Steps < 0 || c.Steps > MaxPatternSteps {
+		return nil, fmt.Errorf("%s steps must be from 1 to %d", c.Command, MaxPatternSteps)
+	}
+	if c.Seed != 0 && c.Command != RelayCommandRandom {
+		return nil, fmt.Errorf("%s does not take a seed", c.Command)
+	}
+
+	// lit returns a step in which the relays for which on returns true are lit.
+	lit := func(on func(k int) bool) []bool {
+		step := make([]bool, n)
+		for k := range step {
+			step[k] = on(k)
+		}
+		return step
+	}
+	steps := make([][]bool, 0)
+	switch c.Command {
+	case RelayCommandChase:
+		for i := range n {
+			steps = append(steps, lit(func(k int) bool { return (k-i+n)%n < width }))
+		}
+	case RelayCommandBounce:
+		positions := make([]int, 0)
+		for i := 0; i <= n-width; i++ {
+			positions = append(positions, i)
+		}
+		for i := n - width - 1; i > 0; i-- {
+			positions = append(positions, i)
+		}
+		for _, i := range positions {
+			steps = append(steps, lit(func(k int) bool { return k >= i && k < i+width }))
+		}
+	case RelayCommandFill:
+		for i := 1; i <= n; i++ {
+			steps = append(steps, lit(func(k int) bool { return k < i }))
+		}
+		for i := 1; i <= n; i++ {
+			steps = append(steps, lit(func(k int) bool { return k >= i }))
+		}
+	case RelayCommandAlternate:
+		steps = append(steps, lit(func(k int) bool { return k%2 == 0 }), lit(func(k int) bool { return k%2 == 1 }))
+	case RelayCommandBinaryCounter:
+		count := c.Steps
+		if count == 0 {
+			if n >= bits.Len(MaxPatternSteps) {
+				return nil, fmt.Errorf("binaryCounter over %d relays needs steps, since counting through every combination would take more than %d groups", n, MaxPatternSteps)
+			}
+			count = 1 << n
+		}
+		for i := range count {
+			steps = append(steps, lit(func(k int) bool { return i&(1<<k) != 0 }))
+		}
+	case RelayCommandRandom:
+		count := c.Steps
+		if count == 0 {
+			count = n
+		}
+		seed := uint64(c.Seed)
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+		}
+		random := rand.New(rand.NewPCG(seed, 0))
+		for range count {
+			steps = append(steps, lit(func(int) bool { return random.IntN(2) == 1 }))
+		}
+	}
+	return steps, nil
+}
+
+// expandPatterns replaces pattern commands with the command groups they generate. The groups as written are kept for
+// Compact.
+func (p *Program) expandPatterns() error {
+	commands, expandedCommands, err := expandGroups(p.Commands, "command group")
+	if err != nil {
+		return err
+	}
+	finally, expandedFinally, err := expandGroups(p.Finally, "finally group")
+	if err != nil {
+		return err
+	}
+	if expandedCommands || expandedFinally {
+		p.written = &writtenGroups{commands: p.Commands, finally: p.Finally}
+		p.Commands, p.Finally = commands, finally
+	}
+	return nil
+}
+
+// writtenGroups are a program's command groups as written, before its pattern commands were expanded.
+type writtenGroups struct {
+	commands [][]Command
+	finally  [][]Command
+}
+
+// expandGroups expands the pattern commands among groups, including those in the branches of if commands, and
+// reports whether there were any. Groups without patterns are shared with the original.
+func expandGroups(groups [][]Command, where string) ([][]Command, bool, error) {
+	result := make([][]Command, 0, len(groups))
+	expanded := false
+	for i, group := range groups {
+		location := fmt.Sprintf("%s %d", where, i+1)
+		if k := slices.IndexFunc(group, func(cmd Command) bool { return cmd.IsPattern() }); k >= 0 {
+			if len(group) > 1 {
+				return nil, false, fmt.Errorf("%s: %s must be alone in its command group", location, group[k].Command)
+			}
+			generated, err := group[0].expand()
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", location, err)
+			}
+			result = append(result, generated...)
+			expanded = true
+			continue
+		}
+
+		for j, cmd := range group {
+			then, expandedThen, err := expandGroups(cmd.Then, location+" then group")
+			if err != nil {
+				return nil, false, err
+			}
+			otherwise, expandedElse, err := expandGroups(cmd.Else, location+" else group")
+			if err != nil {
+				return nil, false, err
+			}
+			if expandedThen || expandedElse {
+				group = slices.Clone(group)
+				group[j].Then, group[j].Else = then, otherwise
+				expanded = true
+			}
+		}
+		result = append(result, group)
+	}
+	return result, expanded, nil
+}
+
+// Compact returns the program as written, with its pattern commands unexpanded.
+func (p *Program) Compact() *Program {
+	if p.written == nil {
+		return p
+	}
+	compact := *p
+	compact.Commands, compact.Finally = p.written.commands, p.written.finally
+	return &compact
+}
@@ -3,26 +3,43 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/schedule"
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
 )
 
 var slugifyRegexp = regexp.MustCompile(`[^a-z0-9]+`)
 
 type ProgramRequest struct {
-	Address               string      `json:"address" example:"modbus.lan:4196"`
-	Commands              [][]Command `json:"commands"`
-	Loops                 int         `json:"loops,omitempty" example:"2"`
-	CommandIntervalMillis int         `json:"commandIntervalMillis,omitempty" example:"200"`
-	Debug                 bool        `json:"debug,omitempty" example:"true"`
+	Address               string         `json:"address" example:"modbus.lan:4196"`
+	Commands              [][]Command    `json:"commands"`
+	Loops                 int            `json:"loops,omitempty" example:"2"`
+	DurationMillis        int            `json:"durationMillis,omitempty" example:"3600000"`
+	Until                 *Until         `json:"until,omitempty" swaggertype:"string" example:"23:00"`
+	CommandIntervalMillis int            `json:"commandIntervalMillis,omitempty" example:"200"`
+	Finally               [][]Command    `json:"finally,omitempty"`
+	OnConflict            ConflictPolicy `json:"onConflict,omitempty" example:"queue"`
+	Relays                []RelayTarget  `json:"relays,omitempty"`
+	Priority              int            `json:"priority,omitempty" example:"0"`
+	DebounceMillis        int            `json:"debounceMillis,omitempty" example:"2000"`
+	CooldownMillis        int            `json:"cooldownMillis,omitempty" example:"5000"`
+	MaxRunsPerMinute      int            `json:"maxRunsPerMinute,omitempty" example:"6"`
+	RestoreState          bool           `json:"restoreState,omitempty" example:"true"`
+	Verify                bool           `json:"verify,omitempty" example:"false"`
+	VerifyRetries         *int           `json:"verifyRetries,omitempty" example:"2"`
+	Schedule              string         `json:"schedule,omitempty" example:"0 17 * * *"`
+	Debug                 bool           `json:"debug,omitempty" example:"true"`
+	Parameters            []Parameter    `json:"parameters,omitempty"`
+	Simulate              *Simulation    `json:"simulate,omitempty"`
 }
 
 type Program struct {
@@ -30,6 +47,15 @@ type Program struct {
 	Slug         string     `json:"slug,omitempty" example:"doorbell"`
 	Path         string     `json:"path,omitempty" example:"/etc/modbus/doorbell.json"`
 	LastModified *time.Time `json:"lastModified,omitempty" example:"2025-09-14T12:00:00Z"`
+	// Values are the parameter values the program was bound to, including defaults.
+	Values map[string]any `json:"values,omitempty" swaggertype:"object"`
+
+	// template is the parsed JSON of a program that declares parameters, from which Bind makes concrete copies.
+	template map[string]any
+	// missing names the required parameters that weren't given values.
+	missing []string
+	// written holds the command groups as written, if the program has pattern commands.
+	written *writtenGroups
 }
 
 func ParseProgramFromFile(path string) (*Program, error) {
@@ -63,6 +89,16 @@ func SlugifyFilename(path string) string {
 }
 
 func ParseProgram(programBytes util.HexBytes) (*Program, error) {
+	var declared struct {
+		Parameters []Parameter `json:"parameters"`
+	}
+	if err := json.Unmarshal(programBytes, &declared); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON program: %w", err)
+	}
+	if len(declared.Parameters) > 0 {
+		return parseTemplate(programBytes, declared.Parameters)
+	}
+
 	var program Program
 	if err := json.Unmarshal(programBytes, &program); err != nil {
 		return nil, fmt.Errorf("failed to parse JSON program: %w", err)
@@ -71,26 +107,346 @@ func ParseProgram(programBytes util.HexBytes) (*Program, error) {
 	if program.Address == "" {
 		return nil, fmt.Errorf("missing required field: address")
 	}
+	if err := program.expandPatterns(); err != nil {
+		return nil, err
+	}
 	return &program, nil
 }
 
-func (p *Program) connect(ctx context.Context) (conn net.Conn, err error) {
-	conn, err = modbus.Connect(ctx, p.Address)
+// DeviceFor returns the device name or address a command is sent to.
+func (p *Program) DeviceFor(cmd Command) string {
+	if cmd.Device != "" {
+		return cmd.Device
+	}
+	return p.Address
+}
+
+// DeviceNames returns every device name or address the program refers to, starting with the program's own, and
+// then those of the programs it runs.
+func (p *Program) DeviceNames(ctx context.Context) []string {
+	names := util.NewSet()
+	names.Add(p.Address)
+	result := []string{p.Address}
+	add := func(name string) {
+		if !names.Contains(name) {
+			names.Add(name)
+			result = append(result, name)
+		}
+	}
+	for _, cmdGroup := range p.allGroups() {
+		for _, cmd := range cmdGroup {
+			var name string
+			switch {
+			case cmd.IsDeviceCommand():
+				name = p.DeviceFor(cmd)
+			case cmd.Condition != nil:
+				name = p.ConditionDevice(cmd.Condition)
+			default:
+				continue
+			}
+			add(name)
+		}
+	}
+	// problems with the programs it runs are reported by Validate
+	subprograms, _ := p.subprograms(ctx)
+	for _, subprogram := range subprograms {
+		for _, name := range subprogram.DeviceNames(withCaller(ctx, p)) {
+			add(name)
+		}
+	}
+	return result
+}
+
+// RelayClaims returns the relays the program uses, by device name: those its commands target, plus any it declares
+// in Relays. Relay numbers are sorted.
+func (p *Program) RelayClaims(ctx context.Context) (map[string][]int, error) {
+	relays := make(map[string]map[int]bool)
+	add := func(name string, relay RelayRef) error {
+		number, err := relay.Resolve(ResolveDevice(ctx, name))
+		if err != nil {
+			return err
+		}
+		if relays[name] == nil {
+			relays[name] = make(map[int]bool)
+		}
+		relays[name][number] = true
+		return nil
+	}
+	for _, cmdGroup := range p.allGroups() {
+		for _, cmd := range cmdGroup {
+			if !cmd.IsDeviceCommand() {
+				continue
+			}
+			name := p.DeviceFor(cmd)
+			numbers, err := cmd.relayNumbers(ResolveDevice(ctx, name))
+			if err != nil {
+				return nil, err
+			}
+			for _, number := range numbers {
+				if err := add(name, RelayNumber(number)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	subprograms, err := p.subprograms(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return conn, nil
+	for slug, subprogram := range subprograms {
+		claims, err := subprogram.RelayClaims(withCaller(ctx, p))
+		if err != nil {
+			return nil, fmt.Errorf("program '%s' in run: %w", slug, err)
+		}
+		for name, numbers := range claims {
+			for _, number := range numbers {
+				if err := add(name, RelayNumber(number)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, target := range p.Relays {
+		name := target.Device
+		if name == "" {
+			name = p.Address
+		}
+		if err := add(name, target.Relay); err != nil {
+			return nil, fmt.Errorf("invalid relay in relays (%s): %w", target.Relay, err)
+		}
+	}
+
+	claims := make(map[string][]int, len(relays))
+	for name, numbers := range relays {
+		claims[name] = slices.Sorted(maps.Keys(numbers))
+	}
+	return claims, nil
 }
 
-func (p *Program) Run(ctx context.Context) error {
-	conn, err := p.connect(ctx)
-	if err != nil {
+// GetOnConflict returns the program's conflict policy, which defaults to ConflictQueue.
+func (p *Program) GetOnConflict() ConflictPolicy {
+	if p.OnConflict == "" {
+		return ConflictQueue
+	}
+	return p.OnConflict
+}
+
+// allGroups returns the program's command groups followed by its finally groups, each followed by the groups in the
+// branches of its if commands, and then a group that switches on each simulated relay.
+func (p *Program) allGroups() [][]Command {
+	groups := withBranches(append(p.Commands[:len(p.Commands):len(p.Commands)], p.Finally...))
+	if p.Simulate != nil {
+		groups = append(groups, p.Simulate.commands())
+	}
+	return groups
+}
+
+func withBranches(groups [][]Command) [][]Command {
+	all := make([][]Command, 0, len(groups))
+	for _, cmdGroup := range groups {
+		all = append(all, cmdGroup)
+		for _, cmd := range cmdGroup {
+			all = append(all, withBranches(cmd.Then)...)
+			all = append(all, withBranches(cmd.Else)...)
+		}
+	}
+	return all
+}
+
+// Validate checks every command against its device, so that problems like unknown relay names are caught before
+// anything is sent.
+func (p *Program) Validate(ctx context.Context) error {
+	switch len(p.missing) {
+	case 0:
+	case 1:
+		return fmt.Errorf("missing value for parameter '%s'", p.missing[0])
+	default:
+		return fmt.Errorf("missing values for parameters '%s'", strings.Join(p.missing, "', '"))
+	}
+	switch p.OnConflict {
+	case "", ConflictQueue, ConflictReject, ConflictPreempt:
+	default:
+		return fmt.Errorf("unknown onConflict policy: %s", p.OnConflict)
+	}
+	if p.DebounceMillis < 0 || p.CooldownMillis < 0 || p.MaxRunsPerMinute < 0 {
+		return fmt.Errorf("debounceMillis, cooldownMillis and maxRunsPerMinute must not be negative")
+	}
+	if p.Loops < -1 {
+		return fmt.Errorf("loops must be a positive count, or -1 to loop until stopped")
+	}
+	if p.VerifyRetries != nil && *p.VerifyRetries < 0 {
+		return fmt.Errorf("verifyRetries must not be negative")
+	}
+	if p.DurationMillis < 0 {
+		return fmt.Errorf("durationMillis must not be negative")
+	}
+	if p.Loops == -1 && p.loopMillis() == 0 {
+		return fmt.Errorf("a program that loops until stopped needs a delay between its command groups")
+	}
+	if p.Schedule != "" {
+		if err := schedule.Validate(p.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+	if p.Simulate != nil {
+		if err := p.validateSimulation(ctx); err != nil {
+			return err
+		}
+	}
+	if err := p.validateGroups(ctx, p.Commands, "command group"); err != nil {
+		return err
+	}
+	if err := p.validateGroups(ctx, p.Finally, "finally group"); err != nil {
+		return err
+	}
+	for _, target := range p.Relays {
+		if target.Relay.IsZero() {
+			return fmt.Errorf("missing relay in relays")
+		}
+	}
+	if err := p.validateSubprograms(ctx); err != nil {
+		return err
+	}
+	_, err := p.RelayClaims(ctx)
+	return err
+}
+
+// validateSimulation checks a simulation, and that its relays exist on their devices.
+func (p *Program) validateSimulation(ctx context.Context) error {
+	if len(p.Commands) > 0 || p.Loops != 0 {
+		return fmt.Errorf("a program with a simulation can't also have commands or loops")
+	}
+	if err := p.Simulate.Validate(); err != nil {
+		return err
+	}
+	for i, cmd := range p.Simulate.commands() {
+		if _, err := cmd.RelayIndex(ResolveDevice(ctx, p.DeviceFor(cmd))); err != nil {
+			return fmt.Errorf("simulated relay %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validateGroups checks the commands in a list of command groups, and in the branches of their if commands. where
+// names the groups in errors.
+func (p *Program) validateGroups(ctx context.Context, groups [][]Command, where string) error {
+	for j, cmdGroup := range groups {
+		for k, cmd := range cmdGroup {
+			device := ResolveDevice(ctx, p.DeviceFor(cmd))
+			err := cmd.Validate(device)
+			if err == nil && cmd.runsAlone() && len(cmdGroup) > 1 {
+				err = fmt.Errorf("%s must be the only command in its group", cmd.Command)
+			}
+			if err == nil && cmd.Condition != nil {
+				err = cmd.Condition.Validate(ResolveDevice(ctx, p.ConditionDevice(cmd.Condition)))
+			}
+			if err != nil {
+				return fmt.Errorf("invalid command in %s %d, command %d (%v): %w", where, j+1, k+1, cmd, err)
+			}
+			if err := p.validateGroups(ctx, cmd.Then, fmt.Sprintf("%s %d then group", where, j+1)); err != nil {
+				return err
+			}
+			if err := p.validateGroups(ctx, cmd.Else, fmt.Sprintf("%s %d else group", where, j+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the program, recording its progress in execution. If ctx is cancelled, the program stops as soon as
+// possible, without waiting for the current delay to elapse. Either way, pending pulses are ended and the finally
+// groups are run before Run returns.
+//
+// If ctx has an Arbiter, the program first waits for its turn on its relays, according to its priority and conflict
+// policy. It may then be suspended between command groups by a program of higher priority; see session.suspend.
+func (p *Program) Run(ctx context.Context, execution *Execution) error {
+	if err := p.Validate(ctx); err != nil {
 		return err
 	}
-	defer util.CloseQuietly(conn)
+	if err := context.Cause(ctx); err != nil {
+		return fmt.Errorf("not started: %w", err)
+	}
+
+	arbiter := arbiterFrom(ctx)
+	var lock *Lock
+	if arbiter != nil {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		var err error
+		lock, err = arbiter.acquire(ctx, p, cancel)
+		if err != nil {
+			return fmt.Errorf("not started: %w", err)
+		}
+		defer arbiter.release(lock)
+	}
 
+	session := newSession(ctx)
+	session.arbiter, session.lock = arbiter, lock
+	defer session.close()
+
+	err := session.connect(ctx, p.DeviceNames(ctx))
+	var before RelayStates
+	if err == nil && p.RestoreState {
+		before, err = p.saveState(ctx, session, execution)
+	}
+	if err == nil {
+		err = p.runCommands(ctx, session, execution, nil)
+		err = session.finishPulses(ctx, err)
+	}
+
+	if len(p.Finally) > 0 {
+		err = errors.Join(err, p.runFinally(ctx, session, execution))
+	}
+	if before != nil {
+		err = errors.Join(err, p.restoreState(ctx, session, execution, before))
+	}
+	return err
+}
+
+// saveState reads the states of the program's relays before it runs, for restoreState.
+func (p *Program) saveState(ctx context.Context, session *session, execution *Execution) (RelayStates, error) {
+	relays, err := p.RelayClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	before, err := session.snapshot(ctx, relays)
+	if err != nil {
+		return nil, fmt.Errorf("not started: failed to save relay states: %w", err)
+	}
+	execution.recordStateBefore(before)
+	return before, nil
+}
+
+// restoreState sets the program's relays back to how they were before it ran, and records how they were left. Like
+// the finally groups, it runs even if the program failed or was cancelled, and is bounded by FinallyTimeout.
+func (p *Program) restoreState(ctx context.Context, session *session, execution *Execution, before RelayStates) error {
+	util.LogDebug(ctx, "Restoring relay states", "states", before)
+	restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), FinallyTimeout)
+	defer cancel()
+
+	err := session.restore(restoreCtx, before)
+	relays := make(map[string][]int, len(before))
+	for name, states := range before {
+		relays[name] = slices.Sorted(maps.Keys(states))
+	}
+	after, readErr := session.snapshot(restoreCtx, relays)
+	if readErr == nil {
+		execution.recordStateAfter(after)
+	}
+	if err = errors.Join(err, readErr); err != nil {
+		return fmt.Errorf("failed to restore relay states: %w", err)
+	}
+	return nil
+}
+
+// runCommands runs the program's command groups for each loop, or until it is stopped if Loops is -1, or its
+// simulation. If the program has a durationMillis or until, or there is an outer deadline, it ends at the last group
+// boundary before the earliest of them.
+func (p *Program) runCommands(ctx context.Context, session *session, execution *Execution, outer *deadline) error {
 	loops := p.Loops
-	if loops <= 0 {
+	if loops == 0 {
 		loops = 1
 	}
 
@@ -98,27 +454,204 @@ func (p *Program) Run(ctx context.Context) error {
 
 	util.LogDebug(ctx, "Starting command execution",
 		"loops", loops)
-	for i := 0; i < loops; i++ {
+	// time.Now includes a monotonic clock reading, so the schedule is unaffected by wall clock changes
+	startTime := time.Now()
+	execution.start(startTime)
+	defer p.reportLateness(ctx, execution)
+
+	var err error
+	if p.Simulate != nil {
+		err = p.simulate(ctx, session, execution, earlier(p.deadline(ctx, startTime), outer))
+	} else {
+		err = p.runGroups(ctx, session, execution, p.Commands, loops, earlier(p.deadline(ctx, startTime), outer), groupScope{})
+	}
+	if errors.Is(err, errStop) {
+		return nil
+	}
+	return err
+}
+
+// deadline returns when a program started at start must stop: the earlier of its durationMillis and until, or nil
+// if it has neither.
+func (p *Program) deadline(ctx context.Context, start time.Time) *deadline {
+	var d *deadline
+	if p.DurationMillis > 0 {
+		d = &deadline{
+			time:   start.Add(time.Duration(p.DurationMillis) * time.Millisecond),
+			reason: fmt.Sprintf("durationMillis %d elapsed", p.DurationMillis),
+		}
+	}
+	if p.Until != nil {
+		until := p.Until.After(start, locationFrom(ctx))
+		if d == nil || until.Before(d.time) {
+			d = &deadline{
+				time:   until,
+				reason: fmt.Sprintf("reached until %s", until.Format(time.RFC3339)),
+			}
+		}
+	}
+	return d
+}
+
+// deadline is when a program must stop looping, and why.
+type deadline struct {
+	time   time.Time
+	reason string
+}
+
+// runFinally runs the finally groups. They run even if the program failed or was cancelled, so they are not
+// themselves cancellable, but they are bounded by FinallyTimeout.
+func (p *Program) runFinally(ctx context.Context, session *session, execution *Execution) error {
+	util.LogDebug(ctx, "Running finally groups", "groupCount", len(p.Finally))
+	finallyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), FinallyTimeout)
+	defer cancel()
+
+	err := p.runGroups(finallyCtx, session, execution, p.Finally, 1, nil, groupScope{finally: true})
+	if errors.Is(err, errStop) {
+		err = nil
+	}
+	err = session.finishPulses(finallyCtx, err)
+	if err != nil {
+		return fmt.Errorf("finally: %w", err)
+	}
+	return nil
+}
+
+// groupScope is where a list of command groups sits in the program.
+type groupScope struct {
+	finally bool
+	// branch is set for the then or else groups of an if, and loop is then the loop the if is in.
+	branch string
+	loop   int
+}
+
+// where describes a command group in this scope, for errors.
+func (s groupScope) where(loop int, group int) string {
+	switch {
+	case s.branch != "":
+		return fmt.Sprintf("%s group %d", s.branch, group)
+	case s.finally:
+		return fmt.Sprintf("finally group %d", group)
+	default:
+		return fmt.Sprintf("loop %d, command group %d", loop, group)
+	}
+}
+
+// runGroups sends each command group at its scheduled time. The schedule is measured from when runGroups is called,
+// so time spent sending commands doesn't push later groups back, but it is pushed back by the time spent in an if or
+// waitFor, since those take as long as they take. Loops of -1 repeat the groups until ctx is cancelled or the
+// deadline, if any, is reached. Reaching the deadline, or a stop command, returns errStop.
+func (p *Program) runGroups(ctx context.Context, session *session, execution *Execution, groups [][]Command, loops int, deadline *deadline, scope groupScope) error {
+	startTime := time.Now()
+	offset := time.Duration(0)
+	// next returns how long to wait for the next group, or for the deadline if the group would start after it
+	next := func() (time.Duration, bool) {
+		planned := startTime.Add(offset)
+		if deadline != nil && !planned.Before(deadline.time) {
+			return time.Until(deadline.time), true
+		}
+		return time.Until(planned), false
+	}
+	for i := 0; loops < 0 || i < loops; i++ {
 		util.LogDebug(ctx, "Starting loop", "loopNumber", i+1, "loopCount", loops)
-		for j, cmdGroup := range p.Commands {
-			util.LogDebug(ctx, "Executing command group", "groupNumber", j+1, "group", cmdGroup)
-			for k, cmd := range cmdGroup {
-				util.LogDebug(ctx, "Executing command", "commandNumber", k+1, "command", cmd)
-				modbusMessage, err := cmd.BuildMessage()
-				if err != nil {
-					return fmt.Errorf("failed to build message in loop %d, command group %d, command %d (%v): %w", i+1, j+1, k+1, cmd, err)
-				}
-				_, _, err = modbus.Send(ctx, conn, modbusMessage)
-				if err != nil {
-					return fmt.Errorf("failure in loop %d, command group %d, command %d (%v): %w", i+1, j+1, k+1, cmd, err)
+		loop := i + 1
+		if scope.branch != "" {
+			loop = scope.loop
+		}
+		for j, cmdGroup := range groups {
+			where := scope.where(loop, j+1)
+			wait, stop := next()
+			if wait > 0 {
+				util.LogDebug(ctx, "Waiting before next command group", "milliseconds", wait.Milliseconds(), "loopNumber", i+1, "commandGroupNumber", j+1)
+			}
+			suspended, err := session.sleep(ctx, execution, wait, !scope.finally)
+			for err == nil && suspended > 0 {
+				// pick up where the program left off, rather than trying to catch up
+				startTime = startTime.Add(suspended)
+				wait, stop = next()
+				suspended, err = session.sleep(ctx, execution, wait, true)
+			}
+			if err != nil {
+				return fmt.Errorf("stopped before %s: %w", where, err)
+			}
+			if stop {
+				util.LogDebug(ctx, "Stopping at deadline", "reason", deadline.reason, "loopNumber", i+1, "commandGroupNumber", j+1)
+				execution.stop(deadline.reason)
+				return errStop
+			}
+			planned := startTime.Add(offset)
+			timing := execution.recordGroup(GroupTiming{
+				Loop:         loop,
+				Group:        j + 1,
+				PlannedTime:  planned,
+				ActualTime:   time.Now(),
+				OffsetMillis: offset.Milliseconds(),
+				Finally:      scope.finally,
+				Branch:       scope.branch,
+			})
+			util.LogDebug(ctx, "Executing command group", "groupNumber", j+1, "group", cmdGroup, "lateMillis", timing.LateMillis)
+			alone := len(cmdGroup) == 1 && cmdGroup[0].runsAlone()
+			switch {
+			case alone && cmdGroup[0].Command == RelayCommandIf:
+				err = p.runIf(ctx, session, execution, cmdGroup[0], timing, deadline, scope)
+			case alone && cmdGroup[0].Command == RelayCommandWaitFor:
+				err = p.waitFor(ctx, session, execution, cmdGroup[0], timing, deadline, scope)
+			case alone:
+				err = p.runSubprograms(ctx, session, execution, cmdGroup[0], timing, deadline, scope)
+			default:
+				if err = session.sendVerifiedGroup(ctx, p, cmdGroup, execution, timing); err != nil {
+					err = fmt.Errorf("failure in %s: %w", where, err)
 				}
 			}
-
-			if p.CommandIntervalMillis > 0 && (j < len(p.Commands)-1 || i < loops-1) {
-				util.LogDebug(ctx, "Waiting before next command group", "milliseconds", p.CommandIntervalMillis, "loopNumber", i+1, "commandGroupNumber", j+1)
-				time.Sleep(time.Duration(p.CommandIntervalMillis) * time.Millisecond)
+			if err != nil {
+				return err
+			}
+			if alone {
+				// carry on from when the if, waitFor or run finished
+				startTime = time.Now().Add(-offset)
+			}
+			if slices.ContainsFunc(cmdGroup, func(cmd Command) bool { return cmd.Command == RelayCommandStop }) {
+				util.LogDebug(ctx, "Stopping at stop command", "loopNumber", i+1, "commandGroupNumber", j+1)
+				execution.stop("stop in " + where)
+				return errStop
 			}
+
+			offset += time.Duration(p.DelayAfter(cmdGroup)) * time.Millisecond
 		}
 	}
 	return nil
 }
+
+func (p *Program) reportLateness(ctx context.Context, execution *Execution) {
+	execution.mutex.Lock()
+	defer execution.mutex.Unlock()
+	if execution.LateGroups > 0 {
+		util.GetLogger(ctx).Warn("Program fell behind schedule",
+			"lateGroups", execution.LateGroups,
+			"maxLateMillis", execution.MaxLateMillis)
+	}
+}
+
+// loopMillis returns how long one loop of the command groups takes.
+func (p *Program) loopMillis() int {
+	total := 0
+	for _, cmdGroup := range p.Commands {
+		total += p.DelayAfter(cmdGroup)
+	}
+	return total
+}
+
+// DelayAfter returns the delay between a command group and the next one: the longest wait in the group, or the
+// program's CommandIntervalMillis if the group has no wait.
+func (p *Program) DelayAfter(group []Command) int {
+	delayMillis := -1
+	for _, cmd := range group {
+		if cmd.Command == RelayCommandWait {
+			delayMillis = max(delayMillis, cmd.DurationMillis)
+		}
+	}
+	if delayMillis < 0 {
+		return p.CommandIntervalMillis
+	}
+	return delayMillis
+}
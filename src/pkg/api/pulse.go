@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// Pulses end on their own timers, independently of the program's command groups. Overlapping commands on the same
+// relay behave as follows:
+//   - A pulse on a relay that is already pulsing extends it; the relay turns off when the later of the two pulses
+//     would have ended.
+//   - Any other command on a pulsing relay cancels the pending turn-off, and the new command takes over. If the
+//     turn-off is already being sent, the new command (or pulse) waits for it, so that it can't land afterwards.
+//   - The program does not finish until every pulse has ended. If it fails or is cancelled, pending pulses end
+//     immediately. Turning a pulsed relay off is never itself cancelled.
+//   - While the program is suspended, its pulses are paused, and they resume with the time they had left.
+
+type pulse struct {
+	ctx      context.Context
+	command  Command
+	name     string
+	device   *modbus.Device
+	timer    *time.Timer
+	deadline time.Time
+	paused   bool
+	remains  time.Duration
+	// done is closed once the pulse has ended, or been cancelled. A pulse stays pending until then.
+	done chan struct{}
+}
+
+type pulses struct {
+	session   *session
+	pending   map[string]*pulse
+	errs      []error
+	suspended bool
+	wg        sync.WaitGroup
+	firing    sync.WaitGroup
+	mutex     sync.Mutex
+}
+
+func newPulses(session *session) *pulses {
+	return &pulses{
+		session: session,
+		pending: make(map[string]*pulse),
+	}
+}
+
+func pulseKey(device *modbus.Device, relayIndex int) string {
+	return fmt.Sprintf("%s/%d", device.Key(), relayIndex)
+}
+
+// start arms (or extends) the turn-off timer for a pulse. It is called just before the pulse's "on" is sent.
+func (ps *pulses) start(ctx context.Context, cmd Command, name string, device *modbus.Device) error {
+	relayIndex, err := cmd.RelayIndex(device)
+	if err != nil {
+		return err
+	}
+	key := pulseKey(device, relayIndex)
+	duration := time.Duration(cmd.DurationMillis) * time.Millisecond
+	deadline := time.Now().Add(duration)
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	for {
+		existing, exists := ps.pending[key]
+		if !exists {
+			break
+		}
+		if existing.paused {
+			// its timer is already stopped; it resumes with the longer of the two durations
+			existing.remains = max(existing.remains, duration)
+			if deadline.After(existing.deadline) {
+				existing.deadline = deadline
+			}
+			util.LogDebug(ctx, "Extended paused pulse", "relay", cmd.Relay, "remains", existing.remains)
+			return nil
+		}
+		if existing.timer.Stop() {
+			if deadline.After(existing.deadline) {
+				existing.deadline = deadline
+			}
+			existing.timer.Reset(time.Until(existing.deadline))
+			util.LogDebug(ctx, "Extended pulse", "relay", cmd.Relay, "endsAt", existing.deadline)
+			return nil
+		}
+		// the earlier pulse is ending; wait for its turn-off, so it can't land after this pulse's "on"
+		ps.mutex.Unlock()
+		<-existing.done
+		ps.mutex.Lock()
+	}
+
+	p := &pulse{
+		ctx:      context.WithoutCancel(ctx),
+		command:  cmd,
+		name:     name,
+		device:   device,
+		deadline: deadline,
+		done:     make(chan struct{}),
+	}
+	ps.wg.Add(1)
+	p.timer = time.AfterFunc(duration, func() {
+		ps.fire(key, p)
+	})
+	ps.pending[key] = p
+	return nil
+}
+
+// cancel drops the pending turn-off for a relay, if there is one. If the turn-off is already being sent, it waits
+// for it instead.
+func (ps *pulses) cancel(device *modbus.Device, relayIndex int) {
+	key := pulseKey(device, relayIndex)
+	ps.mutex.Lock()
+	p, exists := ps.pending[key]
+	if !exists {
+		ps.mutex.Unlock()
+		return
+	}
+	if p.paused || p.timer.Stop() {
+		delete(ps.pending, key)
+		ps.mutex.Unlock()
+		close(p.done)
+		ps.wg.Done()
+		return
+	}
+	ps.mutex.Unlock()
+	<-p.done
+}
+
+// fire ends a pulse when its timer expires, unless the program has been suspended in the meantime, in which case
+// the relay now belongs to another program and the pulse ends as soon as this one resumes.
+func (ps *pulses) fire(key string, p *pulse) {
+	ps.mutex.Lock()
+	if ps.suspended {
+		p.paused = true
+		p.remains = 0
+		ps.mutex.Unlock()
+		return
+	}
+	ps.firing.Add(1)
+	ps.mutex.Unlock()
+
+	defer ps.firing.Done()
+	ps.end(key, p)
+}
+
+func (ps *pulses) end(key string, p *pulse) {
+	defer ps.wg.Done()
+
+	off := p.command
+	off.Command = RelayCommandOff
+	util.LogDebug(p.ctx, "Ending pulse", "relay", off.Relay)
+	err := ps.session.send(p.ctx, off, p.name, p.device)
+
+	ps.mutex.Lock()
+	if ps.pending[key] == p {
+		delete(ps.pending, key)
+	}
+	if err != nil {
+		ps.errs = append(ps.errs, fmt.Errorf("failed to end pulse (%v): %w", p.command, err))
+	}
+	ps.mutex.Unlock()
+	close(p.done)
+}
+
+// endAll ends every pending pulse now rather than waiting for its timer.
+func (ps *pulses) endAll() {
+	ps.mutex.Lock()
+	ending := make(map[string]*pulse)
+	for key, p := range ps.pending {
+		if p.timer.Stop() || p.paused {
+			p.paused = false
+			ending[key] = p
+		}
+	}
+	ps.mutex.Unlock()
+
+	for key, p := range ending {
+		ps.end(key, p)
+	}
+}
+
+// suspend pauses every pending pulse, and waits for any that are already ending.
+func (ps *pulses) suspend() {
+	ps.mutex.Lock()
+	ps.suspended = true
+	for _, p := range ps.pending {
+		if p.timer.Stop() {
+			p.paused = true
+			p.remains = time.Until(p.deadline)
+		}
+	}
+	ps.mutex.Unlock()
+	ps.firing.Wait()
+}
+
+// resume restarts paused pulses with the time they had left.
+func (ps *pulses) resume() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.suspended = false
+	for _, p := range ps.pending {
+		if p.paused {
+			p.paused = false
+			p.deadline = time.Now().Add(p.remains)
+			p.timer.Reset(p.remains)
+		}
+	}
+}
+
+// wait blocks until every pulse has ended, and returns any errors encountered while ending them.
+func (ps *pulses) wait() error {
+	ps.wg.Wait()
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return errors.Join(ps.errs...)
+}
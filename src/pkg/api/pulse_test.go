@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+)
+
+// A pulse on a relay whose pulse is paused while the program is suspended extends the paused pulse, rather than
+// waiting for it to end.
+func TestPulseExtendsPausedPulse(t *testing.T) {
+	ctx := context.Background()
+	ps := newSession(ctx).pulses
+	device := modbus.NewDevice("127.0.0.1:502")
+	pulse := func(millis int) Command {
+		return Command{Command: RelayCommandPulse, Relay: RelayNumber(1), DurationMillis: millis}
+	}
+	if err := ps.start(ctx, pulse(10_000), "a", device); err != nil {
+		t.Fatal(err)
+	}
+	ps.suspend()
+
+	started := make(chan error, 1)
+	go func() {
+		started <- ps.start(ctx, pulse(20_000), "a", device)
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pulse waited for the paused pulse to end")
+	}
+
+	cmd := pulse(0)
+	relayIndex, _ := cmd.RelayIndex(device)
+	if remains := ps.pending[pulseKey(device, relayIndex)].remains; remains != 20*time.Second {
+		t.Errorf("paused pulse remains %v, want 20s", remains)
+	}
+	ps.cancel(device, relayIndex)
+}
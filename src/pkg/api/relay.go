@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+)
+
+// RelayRef identifies a relay either by its one-indexed number or by a label or alias defined on the device. In
+// JSON it is a number (7) or a string ("chime").
+type RelayRef struct {
+	Number int
+	Name   string
+}
+
+func RelayNumber(number int) RelayRef {
+	return RelayRef{Number: number}
+}
+
+func (r RelayRef) IsZero() bool {
+	return r.Number == 0 && r.Name == ""
+}
+
+func (r RelayRef) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return strconv.Itoa(r.Number)
+}
+
+func (r RelayRef) MarshalJSON() ([]byte, error) {
+	if r.Name != "" {
+		return json.Marshal(r.Name)
+	}
+	return json.Marshal(r.Number)
+}
+
+func (r *RelayRef) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*r = RelayRef{Number: number}
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("relay must be a number or a name: %s", data)
+	}
+	if number, err := strconv.Atoi(name); err == nil {
+		*r = RelayRef{Number: number}
+		return nil
+	}
+	*r = RelayRef{Name: name}
+	return nil
+}
+
+// Resolve returns the one-indexed relay number, looking up names on the device.
+func (r RelayRef) Resolve(device *modbus.Device) (int, error) {
+	number := r.Number
+	if r.Name != "" {
+		var found bool
+		number, found = device.RelayNumber(r.Name)
+		if !found {
+			return 0, fmt.Errorf("unknown relay name '%s' for device %s", r.Name, device.DisplayName())
+		}
+	}
+	if number < 1 {
+		return 0, fmt.Errorf("invalid relay number %d; relays are numbered from 1", number)
+	}
+	if device.RelayCount > 0 && number > device.RelayCount {
+		return 0, fmt.Errorf("relay %d is out of range; device %s has %d relays", number, device.DisplayName(), device.RelayCount)
+	}
+	if _, err := device.CoilAddress(number); err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// RelayTarget names a relay on a device. The device defaults to the program's address. In JSON it is an object
+// ({"device": "garage", "relay": 2}), or just a relay (2 or "chime") on the program's own device.
+type RelayTarget struct {
+	Device string   `json:"device,omitempty" example:"garage"`
+	Relay  RelayRef `json:"relay" swaggertype:"string" example:"1"`
+}
+
+func (t *RelayTarget) UnmarshalJSON(data []byte) error {
+	var relay RelayRef
+	if err := relay.UnmarshalJSON(data); err == nil {
+		*t = RelayTarget{Relay: relay}
+		return nil
+	}
+	type relayTarget RelayTarget
+	var target relayTarget
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("relay must be a number, a name, or an object with a relay and device: %s", data)
+	}
+	*t = RelayTarget(target)
+	return nil
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// session holds the connections used during a single program run. Devices are referenced by the name or address
+// used in the program; names that resolve to the same physical device share a connection. If ctx has Connections,
+// the connections are also shared with other programs.
+type session struct {
+	devices map[string]*modbus.Device
+	conns   map[string]*modbus.Conn
+	shared  *Connections
+	arbiter *Arbiter
+	lock    *Lock
+	pulses  *pulses
+	// expected is the state the program has left each relay in, as far as it knows, for verification.
+	expected RelayStates
+	// suspension is the suspension in progress, if any. Parallel subprograms share it, so the program is suspended
+	// once, and sending is held for write while it is, so that no branch sends commands to relays it has handed over.
+	suspension *suspension
+	sending    sync.RWMutex
+	mutex      sync.Mutex
+}
+
+func newSession(ctx context.Context) *session {
+	s := &session{
+		devices:  make(map[string]*modbus.Device),
+		conns:    make(map[string]*modbus.Conn),
+		shared:   connectionsFrom(ctx),
+		expected: make(RelayStates),
+	}
+	s.pulses = newPulses(s)
+	return s
+}
+
+// connect resolves and connects to every named device. If any connection fails, those already opened are closed.
+func (s *session) connect(ctx context.Context, names []string) error {
+	for _, name := range names {
+		if _, err := s.conn(ctx, name); err != nil {
+			s.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) device(ctx context.Context, name string) *modbus.Device {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, exists := s.devices[name]
+	if !exists {
+		device = ResolveDevice(ctx, name)
+		s.devices[name] = device
+	}
+	return device
+}
+
+func (s *session) conn(ctx context.Context, name string) (*modbus.Conn, error) {
+	device := s.device(ctx, name)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := device.Key()
+	conn, exists := s.conns[key]
+	if exists && !conn.Broken() {
+		return conn, nil
+	}
+
+	var err error
+	switch {
+	case s.shared != nil && exists:
+		conn, err = s.shared.reconnect(ctx, device)
+	case s.shared != nil:
+		conn, err = s.shared.acquire(ctx, device)
+	default:
+		if exists {
+			util.LogDebug(ctx, "Reconnecting after interrupted exchange", "address", device.Address)
+			util.CloseQuietly(conn)
+			delete(s.conns, key)
+		}
+		conn, err = modbus.Connect(ctx, device)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.conns[key] = conn
+	return conn, nil
+}
+
+func (s *session) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, conn := range s.conns {
+		if s.shared != nil {
+			s.shared.release(key)
+		} else {
+			util.CloseQuietly(conn)
+		}
+		delete(s.conns, key)
+	}
+}
+
+// deviceCommand is a command along with its position in the program, for error reporting.
+type deviceCommand struct {
+	Command
+	index int
+}
+
+// sendGroup sends a command group. Commands for different devices are sent concurrently; commands for the same
+// device are sent in order.
+func (s *session) sendGroup(ctx context.Context, p *Program, group []Command) error {
+	s.sending.RLock()
+	defer s.sending.RUnlock()
+	byDevice := make(map[string][]deviceCommand)
+	for k, cmd := range group {
+		if !cmd.IsDeviceCommand() {
+			continue
+		}
+		key := s.device(ctx, p.DeviceFor(cmd)).Key()
+		byDevice[key] = append(byDevice[key], deviceCommand{Command: cmd, index: k})
+	}
+
+	keys := make([]string, 0, len(byDevice))
+	for key := range byDevice {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.sendCommands(ctx, p, byDevice[key])
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *session) sendCommands(ctx context.Context, p *Program, commands []deviceCommand) error {
+	for _, cmd := range commands {
+		util.LogDebug(ctx, "Executing command", "commandNumber", cmd.index+1, "command", cmd.Command)
+		name := p.DeviceFor(cmd.Command)
+		device := s.device(ctx, name)
+		if cmd.Command.Command == RelayCommandSet {
+			if err := s.set(ctx, cmd.Command, name, device); err != nil {
+				return fmt.Errorf("command %d (%v) on %s: %w", cmd.index+1, cmd.Command, device.DisplayName(), err)
+			}
+			continue
+		}
+		relayIndex, err := cmd.RelayIndex(device)
+		if err != nil {
+			return fmt.Errorf("failed to build message for command %d (%v): %w", cmd.index+1, cmd.Command, err)
+		}
+		// settle any pulse on this relay first, so its turn-off can't land after this command
+		if cmd.Command.Command == RelayCommandPulse {
+			err = s.pulses.start(ctx, cmd.Command, name, device)
+		} else {
+			s.pulses.cancel(device, relayIndex)
+		}
+		if err == nil {
+			err = s.send(ctx, cmd.Command, name, device)
+		}
+		if err != nil {
+			return fmt.Errorf("command %d (%v) on %s: %w", cmd.index+1, cmd.Command, device.DisplayName(), err)
+		}
+		number, _ := cmd.Relay.Resolve(device)
+		s.track(name, number, cmd.Command.Command)
+	}
+	return nil
+}
+
+// send sends a command on the device's connection.
+func (s *session) send(ctx context.Context, cmd Command, name string, device *modbus.Device) error {
+	message, err := cmd.BuildMessage(device)
+	if err != nil {
+		return err
+	}
+	_, err = s.exchange(ctx, name, message)
+	return err
+}
+
+// exchange sends a request on the device's connection and returns the parsed response. If another program broke a
+// shared connection, the request is retried on a new one.
+func (s *session) exchange(ctx context.Context, name string, message modbus.MessageData) (interface{}, error) {
+	conn, err := s.conn(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, response, err := modbus.Send(ctx, conn, message)
+	if errors.Is(err, modbus.ErrConnBroken) {
+		if conn, err = s.conn(ctx, name); err != nil {
+			return nil, err
+		}
+		_, response, err = modbus.Send(ctx, conn, message)
+	}
+	return response, err
+}
+
+// finishPulses waits for pending pulses to end, or ends them immediately if the run failed or ctx is cancelled.
+func (s *session) finishPulses(ctx context.Context, runErr error) error {
+	if runErr != nil {
+		s.pulses.endAll()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.pulses.wait()
+	}()
+	select {
+	case err := <-done:
+		return errors.Join(runErr, err)
+	case <-ctx.Done():
+		s.pulses.endAll()
+		return errors.Join(runErr, <-done)
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// RelayCommandSet puts every relay of a device in the State given, writing only the relays that aren't already in it.
+const RelayCommandSet RelayCommand = "set"
+
+// DesiredState is the state a set command puts a device's relays in. In JSON it is a bitmask, as a number or as a
+// string like "0b00010101" or "0x15", with relay 1 as the lowest bit; an array of booleans, starting with relay 1; or
+// an object mapping relay names or numbers to booleans. States are logical, so "on" takes the device's polarity into
+// account.
+//
+// A desired state covers every relay of the device if it has a relayCount. Otherwise it covers relays up to the
+// length of the array or bitmask string, or up to the highest relay it names or sets. Covered relays it doesn't turn
+// on are turned off.
+type DesiredState struct {
+	raw json.RawMessage
+	// mask is set for bitmasks, and width for bitmask strings, as a number of bits.
+	mask  *uint64
+	width int
+	list  []bool
+	named map[string]bool
+}
+
+func (d *DesiredState) String() string {
+	return string(d.raw)
+}
+
+func (d *DesiredState) MarshalJSON() ([]byte, error) {
+	return d.raw, nil
+}
+
+func (d *DesiredState) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	parsed := DesiredState{raw: append(json.RawMessage(nil), data...)}
+	var err error
+	switch {
+	case len(data) == 0:
+		err = fmt.Errorf("empty state")
+	case data[0] == '[':
+		err = json.Unmarshal(data, &parsed.list)
+	case data[0] == '{':
+		err = json.Unmarshal(data, &parsed.named)
+	case data[0] == '"':
+		var spec string
+		if err = json.Unmarshal(data, &spec); err == nil {
+			err = parsed.parseMask(spec)
+		}
+	default:
+		var mask uint64
+		err = json.Unmarshal(data, &mask)
+		parsed.mask = &mask
+	}
+	if err != nil {
+		return fmt.Errorf("state must be a bitmask, an array of booleans, or an object of relays and booleans: %w", err)
+	}
+	*d = parsed
+	return nil
+}
+
+// parseMask reads a bitmask string, like "0b00010101" or "0x15".
+func (d *DesiredState) parseMask(spec string) error {
+	digits, base, bitsPerDigit := "", 0, 0
+	switch {
+	case strings.HasPrefix(spec, "0b"):
+		digits, base, bitsPerDigit = spec[2:], 2, 1
+	case strings.HasPrefix(spec, "0x"):
+		digits, base, bitsPerDigit = spec[2:], 16, 4
+	default:
+		return fmt.Errorf("bitmask string '%s' must start with 0b or 0x", spec)
+	}
+	mask, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return fmt.Errorf("invalid bitmask '%s'", spec)
+	}
+	d.mask = &mask
+	d.width = len(digits) * bitsPerDigit
+	return nil
+}
+
+// States returns the logical state of each relay the desired state covers, starting with relay 1.
+func (d *DesiredState) States(device *modbus.Device) ([]bool, error) {
+	named := make(map[int]bool, len(d.named))
+	highest := 0
+	for name, on := range d.named {
+		var relay RelayRef
+		if err := json.Unmarshal(strconv.AppendQuote(nil, name), &relay); err != nil {
+			return nil, err
+		}
+		number, err := relay.Resolve(device)
+		if err != nil {
+			return nil, err
+		}
+		named[number] = on
+		highest = max(highest, number)
+	}
+
+	count := device.RelayCount
+	if count == 0 {
+		switch {
+		case d.list != nil:
+			count = len(d.list)
+		case d.width > 0:
+			count = d.width
+		case d.mask != nil:
+			count = bits.Len64(*d.mask)
+		default:
+			count = highest
+		}
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("state covers no relays; give device %s a relayCount, or set at least one relay", device.DisplayName())
+	}
+
+	states := make([]bool, count)
+	switch {
+	case d.list != nil:
+		if len(d.list) > count {
+			return nil, fmt.Errorf("state has %d relays, but device %s has %d", len(d.list), device.DisplayName(), count)
+		}
+		copy(states, d.list)
+	case d.mask != nil:
+		if bits.Len64(*d.mask) > count {
+			return nil, fmt.Errorf("bitmask sets relay %d, but device %s has %d relays", bits.Len64(*d.mask), device.DisplayName(), count)
+		}
+		for i := range states {
+			states[i] = *d.mask&(1<<i) != 0
+		}
+	default:
+		for number, on := range named {
+			if number > count {
+				return nil, fmt.Errorf("relay %d is beyond the %d relays of device %s", number, count, device.DisplayName())
+			}
+			states[number-1] = on
+		}
+	}
+	if _, err := device.CoilAddress(count); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// relayNumbers returns the relays a device command affects.
+func (c *Command) relayNumbers(device *modbus.Device) ([]int, error) {
+	if c.Command != RelayCommandSet {
+		number, err := c.Relay.Resolve(device)
+		return []int{number}, err
+	}
+	states, err := c.State.States(device)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]int, len(states))
+	for i := range states {
+		numbers[i] = i + 1
+	}
+	return numbers, nil
+}
+
+// set puts a device's relays in the state a set command describes. It reads their current states, and writes only
+// those that differ: with a single Write Multiple Coils request if the device supports it, and otherwise one at a
+// time. Pending pulses on the relays are cancelled.
+func (s *session) set(ctx context.Context, cmd Command, name string, device *modbus.Device) error {
+	desired, err := cmd.State.States(device)
+	if err != nil {
+		return err
+	}
+	numbers := make([]int, len(desired))
+	for i := range desired {
+		numbers[i] = i + 1
+		address, _ := device.CoilAddress(i + 1)
+		s.pulses.cancel(device, int(address))
+	}
+	current, err := s.snapshot(ctx, map[string][]int{name: numbers})
+	if err != nil {
+		return err
+	}
+	changed := make([]int, 0)
+	for _, number := range numbers {
+		if current[name][number] != desired[number-1] {
+			changed = append(changed, number)
+		}
+	}
+	util.LogDebug(ctx, "Setting relays", "device", name, "changed", changed)
+
+	first, last := 0, 0
+	if len(changed) > 0 {
+		first, last = changed[0], changed[len(changed)-1]
+	}
+	switch {
+	case len(changed) == 0:
+	case len(changed) > 1 && device.WriteMultipleCoils && last-first < modbus.MaxWriteMultipleCoils:
+		// relays between the changed ones are already as desired, so they are written as they are
+		values := make([]bool, last-first+1)
+		for i := range values {
+			values[i] = desired[first+i-1] != device.IsInverted(first+i)
+		}
+		start, _ := device.CoilAddress(first)
+		if _, err := s.exchange(ctx, name, modbus.NewWriteMultipleCoils(start, values)); err != nil {
+			return err
+		}
+	default:
+		for _, number := range changed {
+			write := Command{Command: RelayCommandOff, Relay: RelayNumber(number)}
+			if desired[number-1] {
+				write.Command = RelayCommandOn
+			}
+			if err := s.send(ctx, write, name, device); err != nil {
+				return fmt.Errorf("relay %d: %w", number, err)
+			}
+		}
+	}
+
+	for _, number := range numbers {
+		state := RelayCommandOff
+		if desired[number-1] {
+			state = RelayCommandOn
+		}
+		s.track(name, number, state)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// Simulation switches relays on and off at random, so that a house looks occupied. A program with a simulation has
+// no command groups of its own; it runs until its durationMillis or until, or until it is stopped.
+type Simulation struct {
+	// Seed makes the simulation repeat exactly, given the same start time. Without one, each run picks its own, and
+	// records it in its execution.
+	Seed    int64            `json:"seed,omitempty" example:"42"`
+	Windows []Window         `json:"windows,omitempty"`
+	Relays  []SimulatedRelay `json:"relays"`
+}
+
+// SimulatedRelay is a relay that a simulation switches. Each time it turns on, it stays on for a random time within
+// OnMillis, and each time it turns off, it stays off for a random time within OffMillis. It is only on within its
+// Windows, or the simulation's if it has none, or at any time if neither has any.
+type SimulatedRelay struct {
+	Relay     RelayRef    `json:"relay" swaggertype:"string" example:"living room"`
+	Device    string      `json:"device,omitempty" example:"garage"`
+	OnMillis  MillisRange `json:"onMillis"`
+	OffMillis MillisRange `json:"offMillis"`
+	Windows   []Window    `json:"windows,omitempty"`
+}
+
+type MillisRange struct {
+	Min int `json:"min" example:"600000"`
+	Max int `json:"max" example:"3600000"`
+}
+
+// Window is a time of day from From until To, e.g. "17:30" to "23:00". It wraps past midnight if To is earlier than
+// From, and covers the whole day if they are the same.
+type Window struct {
+	From *Until `json:"from" swaggertype:"string" example:"17:30"`
+	To   *Until `json:"to" swaggertype:"string" example:"23:00"`
+}
+
+func (r MillisRange) Validate() error {
+	if r.Min <= 0 || r.Max < r.Min {
+		return fmt.Errorf("min must be positive, and max at least min")
+	}
+	return nil
+}
+
+func (r MillisRange) random(random *rand.Rand) time.Duration {
+	return time.Duration(r.Min+random.IntN(r.Max-r.Min+1)) * time.Millisecond
+}
+
+func (w *Window) Validate() error {
+	if w.From == nil || w.To == nil || w.From.clock == nil || w.To.clock == nil {
+		return fmt.Errorf("window requires a from and a to, as times of day like 17:30")
+	}
+	return nil
+}
+
+// contains reports whether t, read in loc, is within the window.
+func (w *Window) contains(t time.Time, loc *time.Location) bool {
+	hour, minute, second := t.In(loc).Clock()
+	now := hour*3600 + minute*60 + second
+	from, to := secondOfDay(w.From.clock), secondOfDay(w.To.clock)
+	switch {
+	case from < to:
+		return now >= from && now < to
+	case from > to:
+		return now >= from || now < to
+	default:
+		return true
+	}
+}
+
+// length returns how long the window lasts.
+func (w *Window) length() time.Duration {
+	seconds := (secondOfDay(w.To.clock) - secondOfDay(w.From.clock) + 24*3600) % (24 * 3600)
+	if seconds == 0 {
+		seconds = 24 * 3600
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func secondOfDay(clock *time.Time) int {
+	return clock.Hour()*3600 + clock.Minute()*60 + clock.Second()
+}
+
+func (s *Simulation) Validate() error {
+	if len(s.Relays) == 0 {
+		return fmt.Errorf("simulation requires relays")
+	}
+	for _, window := range s.Windows {
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("invalid simulation window: %w", err)
+		}
+	}
+	for i, relay := range s.Relays {
+		if relay.Relay.IsZero() {
+			return fmt.Errorf("simulated relay %d: missing relay", i+1)
+		}
+		if err := relay.OnMillis.Validate(); err != nil {
+			return fmt.Errorf("simulated relay %d: invalid onMillis: %w", i+1, err)
+		}
+		if err := relay.OffMillis.Validate(); err != nil {
+			return fmt.Errorf("simulated relay %d: invalid offMillis: %w", i+1, err)
+		}
+		for _, window := range relay.Windows {
+			if err := window.Validate(); err != nil {
+				return fmt.Errorf("simulated relay %d: invalid window: %w", i+1, err)
+			}
+		}
+		// a relay's off time is counted from the start of its window, so it must fit in one of them
+		windows := relay.Windows
+		if len(windows) == 0 {
+			windows = s.Windows
+		}
+		offMin := time.Duration(relay.OffMillis.Min) * time.Millisecond
+		if len(windows) > 0 && !slices.ContainsFunc(windows, func(w Window) bool { return offMin < w.length() }) {
+			return fmt.Errorf("simulated relay %d: offMillis min is longer than its windows, so it would never turn on", i+1)
+		}
+	}
+	return nil
+}
+
+// commands returns an on command for each simulated relay, so that the relays are validated and claimed like those of
+// any other command.
+func (s *Simulation) commands() []Command {
+	commands := make([]Command, len(s.Relays))
+	for i, relay := range s.Relays {
+		commands[i] = Command{Command: RelayCommandOn, Relay: relay.Relay, Device: relay.Device}
+	}
+	return commands
+}
+
+// SimulatedChange is a command group planned by a simulation: the relays that switch at Time.
+type SimulatedChange struct {
+	Time     time.Time `json:"time" example:"2025-01-01T18:12:43Z"`
+	Commands []Command `json:"commands"`
+}
+
+// Plan returns the changes a simulation with the given seed makes between start and end, with times of day read in
+// loc. The first change, at start, turns every simulated relay off. A run started at start with the same seed makes
+// the same changes.
+func (s *Simulation) Plan(seed int64, start time.Time, end time.Time, loc *time.Location) []SimulatedChange {
+	simulator := s.start(seed, start, loc)
+	changes := make([]SimulatedChange, 0)
+	for change := simulator.initial(); change.Time.Before(end); change = simulator.next() {
+		if len(change.Commands) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// simulator works out a simulation's changes in order. Every random choice is made in that order, from one source,
+// so the changes depend only on the seed, the start time and the location.
+type simulator struct {
+	simulation *Simulation
+	random     *rand.Rand
+	loc        *time.Location
+	start      time.Time
+	on         []bool
+	// due is when each relay next changes, or reconsiders whether it's in one of its windows.
+	due []time.Time
+}
+
+func (s *Simulation) start(seed int64, start time.Time, loc *time.Location) *simulator {
+	return &simulator{
+		simulation: s,
+		random:     rand.New(rand.NewPCG(uint64(seed), 0)),
+		loc:        loc,
+		start:      start,
+		on:         make([]bool, len(s.Relays)),
+		due:        make([]time.Time, len(s.Relays)),
+	}
+}
+
+// initial turns every relay off, and schedules when each first turns on.
+func (s *simulator) initial() SimulatedChange {
+	change := SimulatedChange{Time: s.start}
+	for i, relay := range s.simulation.Relays {
+		change.Commands = append(change.Commands, Command{Command: RelayCommandOff, Relay: relay.Relay, Device: relay.Device})
+		s.reschedule(i, s.start)
+	}
+	return change
+}
+
+// next returns the next change, with every relay due to change at the same time. The change has no commands if the
+// relays that came due were outside their windows, and stayed off; each call moves on in time, so callers can stop
+// at a deadline either way.
+func (s *simulator) next() SimulatedChange {
+	due := s.due[0]
+	for _, t := range s.due[1:] {
+		if t.Before(due) {
+			due = t
+		}
+	}
+	change := SimulatedChange{Time: due}
+	for i, relay := range s.simulation.Relays {
+		if !s.due[i].Equal(due) {
+			continue
+		}
+		if s.on[i] || s.active(i, due) {
+			s.on[i] = !s.on[i]
+			command := RelayCommandOff
+			if s.on[i] {
+				command = RelayCommandOn
+			}
+			change.Commands = append(change.Commands, Command{Command: command, Relay: relay.Relay, Device: relay.Device})
+		}
+		s.reschedule(i, due)
+	}
+	return change
+}
+
+// reschedule works out when relay i next changes, after it has changed, or been considered, at t. A relay that is on
+// turns off after its on time, or at the end of its window. A relay that is off turns on after its off time, counted
+// from t if t is in its window, or from the start of its next window if not.
+func (s *simulator) reschedule(i int, t time.Time) {
+	relay := s.simulation.Relays[i]
+	if s.on[i] {
+		off := t.Add(relay.OnMillis.random(s.random))
+		if end, ok := s.windowEnd(i, t); ok && end.Before(off) {
+			off = end
+		}
+		s.due[i] = off
+		return
+	}
+	from := t
+	if !s.active(i, t) {
+		from = s.windowStart(i, t)
+	}
+	s.due[i] = from.Add(relay.OffMillis.random(s.random))
+}
+
+func (s *simulator) windows(i int) []Window {
+	if windows := s.simulation.Relays[i].Windows; len(windows) > 0 {
+		return windows
+	}
+	return s.simulation.Windows
+}
+
+// active reports whether t is within one of relay i's windows.
+func (s *simulator) active(i int, t time.Time) bool {
+	windows := s.windows(i)
+	for _, window := range windows {
+		if window.contains(t, s.loc) {
+			return true
+		}
+	}
+	return len(windows) == 0
+}
+
+// windowEnd returns when relay i's windows next end after t, if they ever do.
+func (s *simulator) windowEnd(i int, t time.Time) (time.Time, bool) {
+	var end time.Time
+	for _, window := range s.windows(i) {
+		candidate := window.To.After(t, s.loc)
+		if !s.active(i, candidate) && (end.IsZero() || candidate.Before(end)) {
+			end = candidate
+		}
+	}
+	return end, !end.IsZero()
+}
+
+// windowStart returns when relay i's windows next start after t.
+func (s *simulator) windowStart(i int, t time.Time) time.Time {
+	var start time.Time
+	for _, window := range s.windows(i) {
+		candidate := window.From.After(t, s.loc)
+		if start.IsZero() || candidate.Before(start) {
+			start = candidate
+		}
+	}
+	return start
+}
+
+// simulate runs the program's simulation until the deadline, if there is one, or until ctx is cancelled. Each change
+// is recorded as a command group of loop 1, and sent like one.
+func (p *Program) simulate(ctx context.Context, session *session, execution *Execution, deadline *deadline) error {
+	seed := p.Simulate.Seed
+	if seed == 0 {
+		seed = rand.Int64()
+	}
+	execution.recordSimulationSeed(seed)
+	start := time.Now()
+	simulator := p.Simulate.start(seed, start, locationFrom(ctx))
+	util.LogDebug(ctx, "Starting simulation", "seed", seed, "relayCount", len(p.Simulate.Relays))
+
+	group := 0
+	for change := simulator.initial(); ; change = simulator.next() {
+		stop := deadline != nil && !change.Time.Before(deadline.time)
+		until := change.Time
+		if stop {
+			until = deadline.time
+		}
+		// a suspension only pushes back the changes that were due during it
+		for wait := time.Until(until); ; wait = time.Until(until) {
+			suspended, err := session.sleep(ctx, execution, wait, true)
+			if err != nil {
+				return fmt.Errorf("stopped before simulated change %d: %w", group+1, err)
+			}
+			if suspended == 0 {
+				break
+			}
+		}
+		if stop {
+			execution.stop(deadline.reason)
+			return errStop
+		}
+		if len(change.Commands) == 0 {
+			continue
+		}
+
+		group++
+		timing := execution.recordGroup(GroupTiming{
+			Loop:         1,
+			Group:        group,
+			PlannedTime:  change.Time,
+			ActualTime:   time.Now(),
+			OffsetMillis: change.Time.Sub(start).Milliseconds(),
+		})
+		util.LogDebug(ctx, "Executing simulated change", "groupNumber", group, "group", change.Commands, "lateMillis", timing.LateMillis)
+		if err := session.sendVerifiedGroup(ctx, p, change.Commands, execution, timing); err != nil {
+			return fmt.Errorf("failure in simulated change %d: %w", group, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSimulation = `{
+  "windows": [ { "from": "18:00", "to": "23:00" } ],
+  "relays": [
+    { "relay": 1, "onMillis": { "min": 600000, "max": 3600000 }, "offMillis": { "min": 300000, "max": 1800000 } },
+    { "relay": 2, "onMillis": { "min": 300000, "max": 900000 }, "offMillis": { "min": 600000, "max": 2400000 },
+      "windows": [ { "from": "22:00", "to": "01:00" } ] }
+  ]
+}`
+
+var testLocation = time.FixedZone("EST", -5*3600)
+
+func parseSimulation(t *testing.T, data string) *Simulation {
+	t.Helper()
+	var simulation Simulation
+	if err := json.Unmarshal([]byte(data), &simulation); err != nil {
+		t.Fatal(err)
+	}
+	if err := simulation.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &simulation
+}
+
+// describe writes a change as its time of day, followed by its commands.
+func describe(change SimulatedChange) string {
+	parts := []string{change.Time.In(testLocation).Format("15:04:05")}
+	for _, cmd := range change.Commands {
+		parts = append(parts, fmt.Sprintf("%s %v", cmd.Command, cmd.Relay))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestPlan(t *testing.T) {
+	simulation := parseSimulation(t, testSimulation)
+	start := time.Date(2025, time.March, 1, 17, 0, 0, 0, testLocation)
+	plan := simulation.Plan(42, start, start.Add(8*time.Hour), testLocation)
+
+	expected := []string{
+		"17:00:00 off 1 off 2",
+		"18:26:26 on 1",
+		"18:43:15 off 1",
+		"18:50:27 on 1",
+		"19:09:44 off 1",
+		"19:38:53 on 1",
+		"20:35:20 off 1",
+		"20:49:25 on 1",
+		"21:31:48 off 1",
+		"22:00:49 on 1",
+		"22:37:33 off 1",
+		"22:38:49 on 2",
+		"22:46:04 off 2",
+		"22:57:48 on 1",
+		"23:00:00 off 1",
+		"23:03:26 on 2",
+		"23:13:27 off 2",
+		"23:30:07 on 2",
+		"23:40:02 off 2",
+		"00:08:09 on 2",
+		"00:19:10 off 2",
+		"00:51:22 on 2",
+		"00:56:23 off 2",
+	}
+	actual := make([]string, len(plan))
+	for i, change := range plan {
+		actual[i] = describe(change)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected plan:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+
+	again := simulation.Plan(42, start, start.Add(8*time.Hour), testLocation)
+	if !reflect.DeepEqual(plan, again) {
+		t.Errorf("plans with the same seed, start and location differ")
+	}
+}
+
+func TestPlanSwitchesOnlyWithinWindows(t *testing.T) {
+	simulation := parseSimulation(t, testSimulation)
+	start := time.Date(2025, time.March, 1, 12, 0, 0, 0, testLocation)
+	for seed := int64(1); seed <= 20; seed++ {
+		simulator := simulation.start(seed, start, testLocation)
+		for _, change := range simulation.Plan(seed, start, start.Add(72*time.Hour), testLocation) {
+			for _, cmd := range change.Commands {
+				if cmd.Command != RelayCommandOn {
+					continue
+				}
+				i := cmd.Relay.Number - 1
+				if !simulator.active(i, change.Time) {
+					t.Errorf("seed %d: relay %d turned on outside its windows at %s", seed, cmd.Relay.Number, change.Time)
+				}
+			}
+		}
+	}
+}
+
+func TestPlanEndsWhenRelaysCannotTurnOn(t *testing.T) {
+	simulation := parseSimulation(t, `{
+  "windows": [ { "from": "17:30", "to": "18:00" } ],
+  "relays": [ { "relay": 1, "onMillis": { "min": 60000, "max": 60000 }, "offMillis": { "min": 1500000, "max": 3600000 } } ]
+}`)
+	start := time.Date(2025, time.March, 1, 12, 0, 0, 0, testLocation)
+	done := make(chan []SimulatedChange)
+	go func() {
+		done <- simulation.Plan(7, start, start.Add(30*24*time.Hour), testLocation)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("plan did not end")
+	}
+}
+
+func TestValidateRejectsOffTimeLongerThanWindows(t *testing.T) {
+	var simulation Simulation
+	err := json.Unmarshal([]byte(`{
+  "windows": [ { "from": "17:30", "to": "18:00" } ],
+  "relays": [ { "relay": 1, "onMillis": { "min": 60000, "max": 60000 }, "offMillis": { "min": 3600000, "max": 3600000 } } ]
+}`), &simulation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := simulation.Validate(); err == nil {
+		t.Error("expected an error for an offMillis min longer than the window")
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+)
+
+// RelayStates are the logical states of some relays, by device name and relay number.
+type RelayStates map[string]map[int]bool
+
+// snapshot reads the logical states of the given relays, which are listed by device name.
+func (s *session) snapshot(ctx context.Context, relays map[string][]int) (RelayStates, error) {
+	states := make(RelayStates, len(relays))
+	for name, numbers := range relays {
+		device := s.device(ctx, name)
+		first, err := device.CoilAddress(slices.Min(numbers))
+		if err != nil {
+			return nil, err
+		}
+		last, err := device.CoilAddress(slices.Max(numbers))
+		if err != nil {
+			return nil, err
+		}
+		response, err := s.exchange(ctx, name, modbus.NewReadRelays(device, first, last-first+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read relays on %s: %w", device.DisplayName(), err)
+		}
+		coils := response.(*modbus.CoilStates).Coils
+		states[name] = make(map[int]bool, len(numbers))
+		for _, number := range numbers {
+			states[name][number] = coils[strconv.Itoa(number)]
+		}
+	}
+	return states, nil
+}
+
+// restore sets the relays back to the states in a snapshot.
+func (s *session) restore(ctx context.Context, snapshot RelayStates) error {
+	var errs []error
+	for name, states := range snapshot {
+		device := s.device(ctx, name)
+		for number, on := range states {
+			cmd := Command{Command: RelayCommandOff, Relay: RelayNumber(number)}
+			if on {
+				cmd.Command = RelayCommandOn
+			}
+			if err := s.send(ctx, cmd, name, device); err != nil {
+				errs = append(errs, fmt.Errorf("relay %d on %s: %w", number, device.DisplayName(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
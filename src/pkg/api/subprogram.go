@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// ProgramResolver looks up stored programs by slug, for run commands.
+type ProgramResolver interface {
+	ResolveProgram(ctx context.Context, slug string) (*Program, error)
+}
+
+type programResolverKey struct{}
+
+func WithProgramResolver(ctx context.Context, resolver ProgramResolver) context.Context {
+	return context.WithValue(ctx, programResolverKey{}, resolver)
+}
+
+func resolveProgram(ctx context.Context, slug string) (*Program, error) {
+	resolver, ok := ctx.Value(programResolverKey{}).(ProgramResolver)
+	if !ok {
+		return nil, fmt.Errorf("program '%s' not found: no stored programs are available", slug)
+	}
+	return resolver.ResolveProgram(ctx, slug)
+}
+
+// ProgramDir resolves programs from the JSON files in a directory, by slug.
+type ProgramDir string
+
+func (d ProgramDir) ResolveProgram(_ context.Context, slug string) (*Program, error) {
+	files, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read program directory %s: %w", d, err)
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") || SlugifyFilename(file.Name()) != slug {
+			continue
+		}
+		return ParseProgramFromFile(filepath.Join(string(d), file.Name()))
+	}
+	return nil, fmt.Errorf("program '%s' not found", slug)
+}
+
+type callersKey struct{}
+
+// withCaller notes that the programs run with ctx are called by p, so that programs which call themselves are caught.
+func withCaller(ctx context.Context, p *Program) context.Context {
+	callers := slices.Clone(callersFrom(ctx))
+	return context.WithValue(ctx, callersKey{}, append(callers, p.Slug))
+}
+
+func callersFrom(ctx context.Context) []string {
+	callers, _ := ctx.Value(callersKey{}).([]string)
+	return callers
+}
+
+// RunSlugs returns the slugs of the programs called by the program's run commands, without duplicates.
+func (p *Program) RunSlugs() []string {
+	slugs := make([]string, 0)
+	for _, cmdGroup := range p.allGroups() {
+		for _, cmd := range cmdGroup {
+			for _, slug := range cmd.Programs {
+				if !slices.Contains(slugs, slug) {
+					slugs = append(slugs, slug)
+				}
+			}
+		}
+	}
+	return slugs
+}
+
+// subprograms resolves the programs called by the program's run commands, by slug. It fails if any of them calls
+// the program back.
+func (p *Program) subprograms(ctx context.Context) (map[string]*Program, error) {
+	slugs := p.RunSlugs()
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+	callers := append(slices.Clone(callersFrom(ctx)), p.Slug)
+	subprograms := make(map[string]*Program, len(slugs))
+	for _, slug := range slugs {
+		if i := slices.Index(callers, slug); i >= 0 {
+			return nil, fmt.Errorf("program '%s' calls itself: %s", slug, strings.Join(append(callers[i:], slug), " -> "))
+		}
+		subprogram, err := resolveProgram(ctx, slug)
+		if err != nil {
+			return nil, err
+		}
+		subprograms[slug] = subprogram
+	}
+	return subprograms, nil
+}
+
+// validateSubprograms checks the programs called by the program's run commands, and the programs they call.
+func (p *Program) validateSubprograms(ctx context.Context) error {
+	subprograms, err := p.subprograms(ctx)
+	if err != nil {
+		return err
+	}
+	callerCtx := withCaller(ctx, p)
+	for _, slug := range p.RunSlugs() {
+		if err := subprograms[slug].Validate(callerCtx); err != nil {
+			return fmt.Errorf("invalid program '%s' in run: %w", slug, err)
+		}
+	}
+	return nil
+}
+
+// Subprogram is a run of a program called by a run command.
+type Subprogram struct {
+	Slug      string
+	Program   *Program
+	StartTime time.Time
+	EndTime   time.Time
+	Err       error
+	// Where is the command group that called the program, e.g. "loop 1, command group 2".
+	Where     string
+	Execution *Execution
+}
+
+// Subprograms returns the programs called by run commands so far, in the order they started.
+func (e *Execution) Subprograms() []*Subprogram {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return slices.Clone(e.subprograms)
+}
+
+func (e *Execution) startSubprogram(slug string, program *Program, where string) *Subprogram {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	subprogram := &Subprogram{
+		Slug:      slug,
+		Program:   program,
+		StartTime: time.Now(),
+		Where:     where,
+		Execution: NewExecution(),
+	}
+	e.subprograms = append(e.subprograms, subprogram)
+	return subprogram
+}
+
+func (e *Execution) finishSubprogram(subprogram *Subprogram, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	subprogram.EndTime = time.Now()
+	subprogram.Err = err
+}
+
+// runSubprograms runs the programs called by a run command, one after another or, if it is parallel, all at once.
+func (p *Program) runSubprograms(ctx context.Context, session *session, execution *Execution, cmd Command, timing GroupTiming, deadline *deadline, scope groupScope) error {
+	where := scope.where(timing.Loop, timing.Group)
+	subprograms, err := p.subprograms(ctx)
+	if err != nil {
+		return fmt.Errorf("failure in %s: %w", where, err)
+	}
+	ctx = withCaller(ctx, p)
+	run := func(subprogram *Subprogram) error {
+		util.LogDebug(ctx, "Running program", "slug", subprogram.Slug, "parallel", cmd.Parallel)
+		err := subprogram.Program.runInline(ctx, session, subprogram.Execution, deadline)
+		execution.finishSubprogram(subprogram, err)
+		if err != nil {
+			return fmt.Errorf("failure in %s, program '%s': %w", where, subprogram.Slug, err)
+		}
+		return nil
+	}
+
+	if !cmd.Parallel {
+		for _, slug := range cmd.Programs {
+			if err := run(execution.startSubprogram(slug, subprograms[slug], where)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, len(cmd.Programs))
+	var wg sync.WaitGroup
+	for i, slug := range cmd.Programs {
+		subprogram := execution.startSubprogram(slug, subprograms[slug], where)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = run(subprogram)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// runInline runs a program called by a run command on its caller's session, so it shares the caller's connections,
+// relay claims and pulses: its command groups, then its finally groups. The caller's deadline applies to it as well
+// as its own. A stop command ends only the called program.
+func (p *Program) runInline(ctx context.Context, session *session, execution *Execution, outer *deadline) error {
+	err := p.runCommands(ctx, session, execution, outer)
+	if len(p.Finally) == 0 {
+		return err
+	}
+	finallyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), FinallyTimeout)
+	defer cancel()
+	finallyErr := p.runGroups(finallyCtx, session, execution, p.Finally, 1, nil, groupScope{finally: true})
+	if finallyErr != nil && !errors.Is(finallyErr, errStop) {
+		err = errors.Join(err, fmt.Errorf("finally: %w", finallyErr))
+	}
+	return err
+}
+
+// earlier returns whichever of two deadlines comes first. Either may be nil.
+func earlier(a *deadline, b *deadline) *deadline {
+	if a == nil || (b != nil && b.time.Before(a.time)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// sleep waits for d, or until ctx is cancelled. If suspendable and a program of higher priority asks for this one's
+// relays in the meantime, the program is suspended first, and sleep returns how long it was suspended for; the
+// caller should then sleep again for whatever time it still needs.
+func (s *session) sleep(ctx context.Context, execution *Execution, d time.Duration, suspendable bool) (time.Duration, error) {
+	if s.lock == nil || !suspendable {
+		return 0, util.Sleep(ctx, d)
+	}
+	if err := context.Cause(ctx); err != nil {
+		return 0, err
+	}
+	suspend := s.arbiter.suspendRequested(s.lock)
+	select {
+	case <-suspend:
+		return s.suspend(ctx, execution)
+	default:
+	}
+	if d <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0, nil
+	case <-ctx.Done():
+		return 0, context.Cause(ctx)
+	case <-suspend:
+		return s.suspend(ctx, execution)
+	}
+}
+
+// suspension is a suspension of a session, which parallel subprograms wait for together.
+type suspension struct {
+	done     chan struct{}
+	duration time.Duration
+	err      error
+}
+
+// suspend hands the program's relays over to a program of higher priority, and takes them back once it has
+// finished. Pending pulses are paused, and the states of the program's relays are restored before it resumes.
+//
+// Parallel subprograms share the session, so only the first to get here suspends it. The others wait for it to
+// resume, as do any that try to send a command group in the meantime.
+//
+// A suspended program can't touch its relays, so if it is cancelled while suspended, it still waits to resume
+// before it ends its pulses and runs its finally groups.
+func (s *session) suspend(ctx context.Context, execution *Execution) (time.Duration, error) {
+	s.mutex.Lock()
+	if current := s.suspension; current != nil {
+		s.mutex.Unlock()
+		<-current.done
+		return current.duration, current.err
+	}
+	// a branch that saw the request before another branch finished suspending has nothing left to do
+	select {
+	case <-s.arbiter.suspendRequested(s.lock):
+	default:
+		s.mutex.Unlock()
+		return 0, nil
+	}
+	current := &suspension{done: make(chan struct{})}
+	s.suspension = current
+	s.mutex.Unlock()
+
+	current.duration, current.err = s.handOver(ctx, execution)
+	s.mutex.Lock()
+	s.suspension = nil
+	s.mutex.Unlock()
+	close(current.done)
+	return current.duration, current.err
+}
+
+func (s *session) handOver(ctx context.Context, execution *Execution) (time.Duration, error) {
+	logger := util.GetLogger(ctx)
+	start := time.Now()
+	// wait for command groups being sent by parallel branches, and hold back any more until the program resumes
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	s.pulses.suspend()
+	snapshot, err := s.snapshot(ctx, s.lock.Relays)
+	if err != nil {
+		s.pulses.resume()
+		return 0, fmt.Errorf("failed to save relay states before suspending: %w", err)
+	}
+
+	logger.Info("Program suspended", "program", s.lock.Program)
+	<-s.arbiter.suspended(s.lock)
+	suspended := time.Since(start)
+	logger.Info("Program resuming", "program", s.lock.Program, "suspendedMillis", suspended.Milliseconds())
+
+	err = s.restore(context.WithoutCancel(ctx), snapshot)
+	s.pulses.resume()
+	execution.recordSuspension(suspended)
+	if err != nil {
+		return suspended, fmt.Errorf("failed to restore relay states after resuming: %w", err)
+	}
+	return suspended, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+)
+
+// testPrograms resolves programs from a map, by slug.
+type testPrograms map[string]*Program
+
+func (p testPrograms) ResolveProgram(_ context.Context, slug string) (*Program, error) {
+	if program, exists := p[slug]; exists {
+		return program, nil
+	}
+	return nil, fmt.Errorf("program '%s' not found", slug)
+}
+
+func parseTestProgram(t *testing.T, slug string, data string) *Program {
+	t.Helper()
+	program, err := ParseProgram([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	program.Slug = slug
+	return program
+}
+
+// A program of higher priority suspends a program running subprograms in parallel once, and the subprograms send
+// nothing to its relays until it has finished.
+func TestSuspendParallelSubprograms(t *testing.T) {
+	device := newFakeDevice(t, 16)
+	programs := testPrograms{
+		"pa": parseTestProgram(t, "pa", `{"address": "a", "loops": 15, "commandIntervalMillis": 100,
+			"commands": [[{"command": "on", "relay": 1}], [{"command": "off", "relay": 1}]]}`),
+		"pb": parseTestProgram(t, "pb", `{"address": "a", "loops": 15, "commandIntervalMillis": 100,
+			"commands": [[{"command": "on", "relay": 2}], [{"command": "off", "relay": 2}]]}`),
+		"low": parseTestProgram(t, "low", `{"address": "a",
+			"commands": [[{"command": "run", "programs": ["pa", "pb"], "parallel": true}]]}`),
+		"high": parseTestProgram(t, "high", `{"address": "a", "priority": 5, "commands": [
+			[{"command": "on", "relay": 1}, {"command": "on", "relay": 2}],
+			[{"command": "wait", "durationMillis": 1000}],
+			[{"command": "off", "relay": 1}, {"command": "off", "relay": 2}]]}`),
+	}
+	ctx := WithDeviceResolver(context.Background(), Devices{"a": modbus.NewDevice(device.address)})
+	ctx = WithProgramResolver(ctx, programs)
+	ctx = WithArbiter(ctx, NewArbiter())
+
+	low := make(chan error, 1)
+	go func() {
+		low <- programs["low"].Run(ctx, NewExecution())
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	high := make(chan error, 1)
+	go func() {
+		high <- programs["high"].Run(ctx, NewExecution())
+	}()
+	time.Sleep(300 * time.Millisecond)
+	for range 12 {
+		if !device.coil(1) || !device.coil(2) {
+			t.Fatalf("relays changed while suspended: relay 1 %t, relay 2 %t", device.coil(1), device.coil(2))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for name, done := range map[string]chan error{"high": high, "low": low} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s did not finish", name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Until is when a program stops looping. In JSON it is a timestamp ("2025-12-24T23:00:00-05:00"), or a time of day
+// ("23:00" or "23:00:30"), meaning the next time the clock reads that after the program starts.
+type Until struct {
+	spec string
+	// timestamp is set for timestamps, and clock for times of day.
+	timestamp time.Time
+	clock     *time.Time
+}
+
+func ParseUntil(spec string) (*Until, error) {
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return &Until{spec: spec, timestamp: t}, nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, spec); err == nil {
+			return &Until{spec: spec, clock: &t}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid until '%s': expected a timestamp like 2025-12-24T23:00:00-05:00 or a time of day like 23:00", spec)
+}
+
+func (u *Until) String() string {
+	return u.spec
+}
+
+func (u *Until) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.spec)
+}
+
+func (u *Until) UnmarshalJSON(data []byte) error {
+	var spec string
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("until must be a timestamp or a time of day: %s", data)
+	}
+	parsed, err := ParseUntil(spec)
+	if err != nil {
+		return err
+	}
+	*u = *parsed
+	return nil
+}
+
+// After returns when a program that started at start should stop. Times of day are read in loc.
+func (u *Until) After(start time.Time, loc *time.Location) time.Time {
+	if u.clock == nil {
+		return u.timestamp
+	}
+	year, month, day := start.In(loc).Date()
+	stop := time.Date(year, month, day, u.clock.Hour(), u.clock.Minute(), u.clock.Second(), 0, loc)
+	if !stop.After(start) {
+		stop = time.Date(year, month, day+1, u.clock.Hour(), u.clock.Minute(), u.clock.Second(), 0, loc)
+	}
+	return stop
+}
+
+type locationKey struct{}
+
+// WithLocation sets the time zone in which programs run with ctx read times of day.
+func WithLocation(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, loc)
+}
+
+func locationFrom(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(locationKey{}).(*time.Location); ok {
+		return loc
+	}
+	return time.Local
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// DefaultVerifyRetries is how many times a verified command group is corrected and read back again before the
+// program fails, unless the program sets verifyRetries.
+const DefaultVerifyRetries = 2
+
+// Mismatch is a relay that, when read back after a verified command group, wasn't in the state the program had set.
+type Mismatch struct {
+	Device   string `json:"device" example:"garage"`
+	Relay    int    `json:"relay" example:"7"`
+	Expected bool   `json:"expected" example:"true"`
+	Actual   bool   `json:"actual" example:"false"`
+	Loop     int    `json:"loop" example:"1"`
+	Group    int    `json:"group" example:"2"`
+	Finally  bool   `json:"finally,omitempty" example:"false"`
+	// Attempt is 0 for the first read back, and counts retries after that.
+	Attempt int `json:"attempt" example:"0"`
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s relay %d is %s, expected %s", m.Device, m.Relay, onOff(m.Actual), onOff(m.Expected))
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// VerifyError is returned when relays still don't match after every retry.
+type VerifyError struct {
+	Retries    int
+	Mismatches []Mismatch
+}
+
+func (e *VerifyError) Error() string {
+	descriptions := make([]string, len(e.Mismatches))
+	for i, mismatch := range e.Mismatches {
+		descriptions[i] = mismatch.String()
+	}
+	retries := "retries"
+	if e.Retries == 1 {
+		retries = "retry"
+	}
+	return fmt.Sprintf("verification failed after %d %s: %s", e.Retries, retries, strings.Join(descriptions, "; "))
+}
+
+// GetVerifyRetries returns the program's verifyRetries, which defaults to DefaultVerifyRetries. Zero fails the
+// program on the first mismatch.
+func (p *Program) GetVerifyRetries() int {
+	if p.VerifyRetries == nil {
+		return DefaultVerifyRetries
+	}
+	return *p.VerifyRetries
+}
+
+// verifies reports whether a command is read back after its group. Pulses are never verified, since their relays
+// change on their own timer.
+func (p *Program) verifies(cmd Command) bool {
+	switch cmd.Command {
+	case RelayCommandOn, RelayCommandOff, RelayCommandToggle, RelayCommandSet:
+		return p.Verify || cmd.Verify
+	default:
+		return false
+	}
+}
+
+// sendVerifiedGroup sends a command group, then reads back the relays of its verified commands. Relays that don't
+// match are set again, up to the program's verifyRetries, before the program fails with a VerifyError. Toggles of
+// relays whose state the program doesn't know yet are read before the group is sent, so their expected state is
+// known.
+func (s *session) sendVerifiedGroup(ctx context.Context, p *Program, group []Command, execution *Execution, timing GroupTiming) error {
+	relays := make(map[string][]int)
+	unknown := make(map[string][]int)
+	for _, cmd := range group {
+		if !p.verifies(cmd) {
+			continue
+		}
+		name := p.DeviceFor(cmd)
+		numbers, err := cmd.relayNumbers(s.device(ctx, name))
+		if err != nil {
+			return err
+		}
+		for _, number := range numbers {
+			if !slices.Contains(relays[name], number) {
+				relays[name] = append(relays[name], number)
+			}
+			if _, known := s.expectedState(name, number); cmd.Command == RelayCommandToggle && !known {
+				unknown[name] = append(unknown[name], number)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		states, err := s.snapshot(ctx, unknown)
+		if err != nil {
+			return fmt.Errorf("failed to read relays before toggling: %w", err)
+		}
+		s.setExpected(states)
+	}
+
+	if err := s.sendGroup(ctx, p, group); err != nil || len(relays) == 0 {
+		return err
+	}
+
+	retries := p.GetVerifyRetries()
+	for attempt := 0; ; attempt++ {
+		actual, err := s.snapshot(ctx, relays)
+		if err != nil {
+			return fmt.Errorf("failed to read relays back: %w", err)
+		}
+		mismatches := make([]Mismatch, 0)
+		corrections := make(RelayStates)
+		for _, name := range slices.Sorted(maps.Keys(relays)) {
+			for _, number := range slices.Sorted(slices.Values(relays[name])) {
+				expected, known := s.expectedState(name, number)
+				if !known || actual[name][number] == expected {
+					continue
+				}
+				mismatches = append(mismatches, Mismatch{
+					Device:   name,
+					Relay:    number,
+					Expected: expected,
+					Actual:   actual[name][number],
+					Loop:     timing.Loop,
+					Group:    timing.Group,
+					Finally:  timing.Finally,
+					Attempt:  attempt,
+				})
+				if corrections[name] == nil {
+					corrections[name] = make(map[int]bool)
+				}
+				corrections[name][number] = expected
+			}
+		}
+		execution.recordVerification(mismatches)
+		if len(mismatches) == 0 {
+			return nil
+		}
+		if attempt == retries {
+			return &VerifyError{Retries: retries, Mismatches: mismatches}
+		}
+		if err := s.restore(ctx, corrections); err != nil {
+			return fmt.Errorf("failed to correct relays: %w", err)
+		}
+	}
+}
+
+// track records the state a command leaves its relay in, so that it can be verified. Pulses leave it unknown.
+func (s *session) track(name string, number int, cmd RelayCommand) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states := s.expected[name]
+	if states == nil {
+		states = make(map[int]bool)
+		s.expected[name] = states
+	}
+	state, known := states[number]
+	switch {
+	case cmd == RelayCommandOn:
+		states[number] = true
+	case cmd == RelayCommandOff:
+		states[number] = false
+	case cmd == RelayCommandToggle && known:
+		states[number] = !state
+	default:
+		delete(states, number)
+	}
+}
+
+func (s *session) expectedState(name string, number int) (bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, known := s.expected[name][number]
+	return state, known
+}
+
+func (s *session) setExpected(states RelayStates) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name, numbers := range states {
+		if s.expected[name] == nil {
+			s.expected[name] = make(map[int]bool)
+		}
+		maps.Copy(s.expected[name], numbers)
+	}
+}
@@ -0,0 +1,197 @@
+package modbus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Framing string
+
+const (
+	// FramingTCP is standard Modbus TCP, with an MBAP header in front of each PDU.
+	FramingTCP Framing = "tcp"
+	// FramingRTUOverTCP sends Modbus RTU frames (unit ID, PDU, CRC) over a TCP socket. Many
+	// serial-to-Ethernet gateways, including Waveshare's in transparent mode, expect this.
+	FramingRTUOverTCP Framing = "rtu-over-tcp"
+)
+
+type Polarity string
+
+const (
+	// PolarityNormal means an energized coil is "on".
+	PolarityNormal Polarity = "normal"
+	// PolarityInverted means a de-energized coil is "on", e.g. for loads wired to normally-closed contacts.
+	PolarityInverted Polarity = "inverted"
+)
+
+const (
+	DefaultUnitID               byte = 0x01
+	DefaultConnectTimeoutMillis      = 5000
+)
+
+// Device describes a Modbus device: where to find it, how to talk to it, and how its relays are presented.
+type Device struct {
+	Name                  string              `json:"name,omitempty" example:"garage"`
+	Address               string              `json:"address" example:"modbus.lan:4196"`
+	UnitID                *byte               `json:"unitId,omitempty" example:"1"`
+	Framing               Framing             `json:"framing,omitempty" example:"tcp"`
+	ConnectTimeoutMillis  int                 `json:"connectTimeoutMillis,omitempty" example:"5000"`
+	ResponseTimeoutMillis int                 `json:"responseTimeoutMillis,omitempty" example:"2000"`
+	CoilBase              int                 `json:"coilBase,omitempty" example:"256"`
+	RelayCount            int                 `json:"relayCount,omitempty" example:"8"`
+	Labels                map[string]string   `json:"labels,omitempty"`
+	Aliases               map[string]int      `json:"aliases,omitempty"`
+	Polarity              map[string]Polarity `json:"polarity,omitempty"`
+	// WriteMultipleCoils says the device supports Write Multiple Coils (function 0x0F), so that a set command can
+	// change several relays with one request.
+	WriteMultipleCoils bool `json:"writeMultipleCoils,omitempty" example:"true"`
+}
+
+// NewDevice returns a device with default settings for a raw address.
+func NewDevice(address string) *Device {
+	return &Device{
+		Address: address,
+	}
+}
+
+func (d *Device) Validate() error {
+	if d.Address == "" {
+		return fmt.Errorf("missing required field: address")
+	}
+	switch d.Framing {
+	case "", FramingTCP, FramingRTUOverTCP:
+	default:
+		return fmt.Errorf("unknown framing: %s", d.Framing)
+	}
+	if d.ConnectTimeoutMillis < 0 {
+		return fmt.Errorf("connectTimeoutMillis must not be negative")
+	}
+	if d.ResponseTimeoutMillis < 0 {
+		return fmt.Errorf("responseTimeoutMillis must not be negative")
+	}
+	if d.CoilBase < 0 || d.CoilBase > 0xFFFF {
+		return fmt.Errorf("coilBase must be between 0 and 65535")
+	}
+	if d.RelayCount < 0 || d.CoilBase+d.RelayCount > 0x10000 {
+		return fmt.Errorf("relayCount must not be negative, and coilBase plus relayCount must not exceed 65536 (at most %d relays for coilBase %d)", 0x10000-d.CoilBase, d.CoilBase)
+	}
+	for relay := range d.Labels {
+		if number, err := strconv.Atoi(relay); err != nil || number < 1 {
+			return fmt.Errorf("invalid relay number in labels: %s", relay)
+		}
+	}
+	for relay, polarity := range d.Polarity {
+		if number, err := strconv.Atoi(relay); err != nil || number < 1 {
+			return fmt.Errorf("invalid relay number in polarity: %s", relay)
+		}
+		switch polarity {
+		case PolarityNormal, PolarityInverted:
+		default:
+			return fmt.Errorf("unknown polarity for relay %s: %s", relay, polarity)
+		}
+	}
+	names := make(map[string]bool)
+	for _, label := range d.Labels {
+		name := strings.ToLower(label)
+		if names[name] {
+			return fmt.Errorf("duplicate relay label: %s", label)
+		}
+		names[name] = true
+	}
+	for alias, number := range d.Aliases {
+		if number < 1 {
+			return fmt.Errorf("invalid relay number for alias '%s': %d", alias, number)
+		}
+		name := strings.ToLower(alias)
+		if names[name] {
+			return fmt.Errorf("relay alias duplicates another label or alias: %s", alias)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// DisplayName returns the device's name if it has one, or its address otherwise.
+func (d *Device) DisplayName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.Address
+}
+
+// RelayNumber looks up a relay by label or alias. Names are case-insensitive.
+func (d *Device) RelayNumber(name string) (int, bool) {
+	for relay, label := range d.Labels {
+		if strings.EqualFold(label, name) {
+			number, err := strconv.Atoi(relay)
+			return number, err == nil
+		}
+	}
+	for alias, number := range d.Aliases {
+		if strings.EqualFold(alias, name) {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// CoilAddress maps a one-indexed relay number onto the device's coil range.
+func (d *Device) CoilAddress(relay int) (uint16, error) {
+	address := d.CoilBase + relay - 1
+	if relay < 1 || address > 0xFFFF {
+		return 0, fmt.Errorf("relay %d is outside the coil address range of device %s", relay, d.DisplayName())
+	}
+	return uint16(address), nil
+}
+
+// RelayNumberForCoil is the inverse of CoilAddress.
+func (d *Device) RelayNumberForCoil(address uint16) int {
+	return int(address) - d.CoilBase + 1
+}
+
+// IsInverted reports whether the relay's logical state is the opposite of its coil state.
+func (d *Device) IsInverted(relay int) bool {
+	return d.Polarity[strconv.Itoa(relay)] == PolarityInverted
+}
+
+// HasInvertedRelays reports whether any relay's logical state differs from its coil state.
+func (d *Device) HasInvertedRelays() bool {
+	for _, polarity := range d.Polarity {
+		if polarity == PolarityInverted {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies the physical device. Two device names pointing at the same address and unit ID share a key.
+func (d *Device) Key() string {
+	return fmt.Sprintf("%s#%d", d.Address, d.GetUnitID())
+}
+
+func (d *Device) GetUnitID() byte {
+	if d.UnitID == nil {
+		return DefaultUnitID
+	}
+	return *d.UnitID
+}
+
+func (d *Device) GetFraming() Framing {
+	if d.Framing == "" {
+		return FramingTCP
+	}
+	return d.Framing
+}
+
+func (d *Device) ConnectTimeout() time.Duration {
+	if d.ConnectTimeoutMillis == 0 {
+		return DefaultConnectTimeoutMillis * time.Millisecond
+	}
+	return time.Duration(d.ConnectTimeoutMillis) * time.Millisecond
+}
+
+func (d *Device) ResponseTimeout() time.Duration {
+	return time.Duration(d.ResponseTimeoutMillis) * time.Millisecond
+}
@@ -3,6 +3,8 @@ package modbus
 type FunctionCode byte
 
 const (
-	ReadCoilsFunction       FunctionCode = 0x01
-	WriteSingleCoilFunction FunctionCode = 0x05
+	ReadCoilsFunction          FunctionCode = 0x01
+	ReadDiscreteInputsFunction FunctionCode = 0x02
+	WriteSingleCoilFunction    FunctionCode = 0x05
+	WriteMultipleCoilsFunction FunctionCode = 0x0F
 )
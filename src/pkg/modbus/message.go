@@ -4,19 +4,20 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
-	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
 )
 
-var lastTransactionId uint16 = 0
+var lastTransactionId atomic.Uint32
 
 // Message represents a Modbus TCP message frame.
 // Modbus TCP frame structure:
 // 0-1 Transaction ID: value that will be echoed back in the response
 // 2-3 Protocol ID: 0x0000
 // 4-5 Length: number of bytes remaining in this message
-// 7 Unit ID: always 0x01
+// 6 Unit ID: 0x01 unless the device is configured otherwise
 // 7-X: Data
 
 type Message struct {
@@ -51,18 +52,17 @@ type MessageData interface {
 }
 
 func NextTransactionId() uint16 {
-	lastTransactionId = lastTransactionId + 1
-	return lastTransactionId
+	return uint16(lastTransactionId.Add(1))
 }
 
-func createMessage(data MessageData) *Message {
+func createMessage(data MessageData, unitID byte) *Message {
 	dataBytes := data.ToDataBytes()
 
 	header := &MessageHeader{
 		TransactionID: NextTransactionId(),
 		ProtocolID:    0,
 		Length:        uint16(len(dataBytes) + 1),
-		UnitID:        1,
+		UnitID:        unitID,
 	}
 
 	return &Message{
@@ -84,7 +84,61 @@ func (m *Message) ToBytes() util.HexBytes {
 	return m.Bytes
 }
 
-func (m *Message) sendMessage(ctx context.Context, conn net.Conn) (*Response, error) {
+// ToRTUBytes renders the message as a Modbus RTU frame: unit ID, PDU, and a little-endian CRC-16.
+func (m *Message) ToRTUBytes() util.HexBytes {
+	frame := make([]byte, 0, len(m.Data)+3)
+	frame = append(frame, m.Header.UnitID)
+	frame = append(frame, m.Data...)
+	crc := crc16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// sendMessage writes the message and reads the response. If ctx is cancelled part-way, the exchange is interrupted
+// and the connection is marked broken.
+func (m *Message) sendMessage(ctx context.Context, conn *Conn) (response *Response, err error) {
+	if err = context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	if conn.Broken() {
+		return nil, ErrConnBroken
+	}
+	if timeout := conn.Device.ResponseTimeout(); timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+		close(interrupted)
+	})
+	defer func() {
+		if !stop() {
+			<-interrupted
+			if err != nil {
+				err = fmt.Errorf("%w (%v)", context.Cause(ctx), err)
+			}
+		}
+		if err != nil {
+			conn.broken.Store(true)
+		}
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	return m.exchange(ctx, conn)
+}
+
+func (m *Message) exchange(ctx context.Context, conn *Conn) (*Response, error) {
+	if conn.Device.GetFraming() == FramingRTUOverTCP {
+		frame := m.ToRTUBytes()
+		util.LogDebug(ctx, "Sending", "frame", frame)
+		if _, err := conn.Write(frame); err != nil {
+			return nil, err
+		}
+		return ReadRTUResponse(ctx, conn, m)
+	}
+
 	messageBytes := m.ToBytes()
 	util.LogDebug(ctx, "Sending", "header", m.Header.ToBytes(), "payload", m.Data)
 	_, err := conn.Write(messageBytes)
@@ -95,9 +149,11 @@ func (m *Message) sendMessage(ctx context.Context, conn net.Conn) (*Response, er
 	return ReadResponse(ctx, conn)
 }
 
-func Send(ctx context.Context, conn net.Conn, messageData MessageData) (*Message, interface{}, error) {
-	msg := createMessage(messageData)
+func Send(ctx context.Context, conn *Conn, messageData MessageData) (*Message, interface{}, error) {
+	msg := createMessage(messageData, conn.Device.GetUnitID())
+	conn.mutex.Lock()
 	response, err := msg.sendMessage(ctx, conn)
+	conn.mutex.Unlock()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -2,40 +2,68 @@ package modbus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
 )
 
-func Connect(ctx context.Context, addr string) (net.Conn, error) {
+// ErrConnBroken is returned when sending on a broken connection. Nothing was sent, so the request can safely be
+// retried on a new connection.
+var ErrConnBroken = errors.New("connection is broken")
+
+// Conn is an open connection to a Modbus device. It is safe to Send on a Conn from multiple goroutines; each
+// request and its response are exchanged without interleaving.
+type Conn struct {
+	net.Conn
+	Device *Device
+	mutex  sync.Mutex
+	broken atomic.Bool
+}
+
+// Broken reports whether an exchange on this connection failed part-way, e.g. because it timed out or was
+// cancelled. The connection may then hold a stray response, so it shouldn't be reused.
+func (c *Conn) Broken() bool {
+	return c.broken.Load()
+}
+
+func Connect(ctx context.Context, device *Device) (*Conn, error) {
+	addr := device.Address
 	util.LogDebug(ctx, "Connecting", "address", addr)
-	conn, err := net.DialTimeout("tcp", addr, time.Second*5)
+	dialer := net.Dialer{Timeout: device.ConnectTimeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
 	util.LogDebug(ctx, "Connected", "address", addr)
-	return conn, nil
+	return &Conn{
+		Conn:   conn,
+		Device: device,
+	}, nil
 }
 
-func GetStatus(ctx context.Context, addr string) (*CoilStates, error) {
-	conn, err := Connect(ctx, addr)
+func GetStatus(ctx context.Context, device *Device) (*CoilStates, error) {
+	conn, err := Connect(ctx, device)
 	if err != nil {
 		return nil, err
 	}
 	defer util.CloseQuietly(conn)
 
-	coilCount, err := GetRelayCount(ctx, addr, conn)
+	addr := device.Address
+	coilCount, err := GetRelayCount(ctx, conn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get relay count for %s: %w", addr, err)
 	}
 
-	msgData := NewReadCoils(0, coilCount)
+	msgData := NewReadRelays(device, uint16(device.CoilBase), coilCount)
 	_, response, err := Send(ctx, conn, msgData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read relay states for %s: %w", addr, err)
 	}
 	relayStates := response.(*CoilStates)
+	relayStates.Labels = device.Labels
 	return relayStates, nil
 }
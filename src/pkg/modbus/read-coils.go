@@ -9,8 +9,12 @@ import (
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
 )
 
+// CoilStates maps relay numbers to their logical state. Raw coil states are included as well when the device has
+// inverted relays.
 type CoilStates struct {
-	Coils map[string]bool `json:"coils"`
+	Coils  map[string]bool   `json:"coils"`
+	Raw    map[string]bool   `json:"raw,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type ReadCoils struct {
@@ -18,6 +22,7 @@ type ReadCoils struct {
 	FunctionCode  byte
 	StartAddress  uint16
 	Quantity      uint16
+	Device        *Device
 }
 
 func NewReadCoils(startAddress, quantity uint16) *ReadCoils {
@@ -43,11 +48,12 @@ func (w *ReadCoils) ValidateResponse(msg *Message, response *Response) error {
 	if response.MessageHeader.TransactionID != msg.Header.TransactionID {
 		validationErrors = append(validationErrors, fmt.Errorf("response transaction ID %x does not match request transaction ID %x", response.MessageHeader.TransactionID, msg.Header.TransactionID))
 	}
-	if err := validateFunctionCode(responseData[1], ReadCoilsFunction); err != nil {
+	if err := validateFunctionCode(responseData[0], FunctionCode(w.FunctionCode)); err != nil {
 		validationErrors = append(validationErrors, err)
 	}
-	if len(responseData) != 3 {
-		validationErrors = append(validationErrors, fmt.Errorf("response data length is %d, expected 4", len(responseData)))
+	expectedByteCount := (int(w.Quantity) + 7) / 8
+	if len(responseData) != 2+expectedByteCount {
+		validationErrors = append(validationErrors, fmt.Errorf("response data length is %d, expected %d", len(responseData), 2+expectedByteCount))
 	}
 
 	if len(validationErrors) == 0 {
@@ -56,16 +62,38 @@ func (w *ReadCoils) ValidateResponse(msg *Message, response *Response) error {
 	return errors.Join(validationErrors...)
 }
 
+// NewReadRelays reads coils starting at the given address and reports their states as seen through the device's
+// relay polarity.
+func NewReadRelays(device *Device, startAddress, quantity uint16) *ReadCoils {
+	readCoils := NewReadCoils(startAddress, quantity)
+	readCoils.Device = device
+	return readCoils
+}
+
 func (w *ReadCoils) ParseResponse(response *Response) (interface{}, error) {
 	result := make(map[string]bool)
+	raw := make(map[string]bool)
 	responseData := response.Data
 
 	for i := 0; i < int(w.Quantity); i++ {
 		byteIndex := 2 + (i / 8)
 		bitIndex := i % 8
-		result[strconv.Itoa(i+1)] = (responseData[byteIndex] & (1 << bitIndex)) != 0
+		relay := int(w.StartAddress) + i + 1
+		if w.Device != nil {
+			relay = w.Device.RelayNumberForCoil(w.StartAddress + uint16(i))
+		}
+		key := strconv.Itoa(relay)
+		raw[key] = (responseData[byteIndex] & (1 << bitIndex)) != 0
+		result[key] = raw[key]
+		if w.Device != nil && w.Device.IsInverted(relay) {
+			result[key] = !raw[key]
+		}
 	}
-	return &CoilStates{
+	states := &CoilStates{
 		Coils: result,
-	}, nil
+	}
+	if w.Device != nil && w.Device.HasInvertedRelays() {
+		states.Raw = raw
+	}
+	return states, nil
 }
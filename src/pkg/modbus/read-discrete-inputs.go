@@ -0,0 +1,9 @@
+package modbus
+
+// NewReadDiscreteInputs reads discrete inputs starting at the given address. The response has the same shape as a
+// ReadCoils response; its states are keyed by one-indexed input number.
+func NewReadDiscreteInputs(startAddress, quantity uint16) *ReadCoils {
+	readInputs := NewReadCoils(startAddress, quantity)
+	readInputs.FunctionCode = byte(ReadDiscreteInputsFunction)
+	return readInputs
+}
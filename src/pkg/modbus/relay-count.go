@@ -4,38 +4,54 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
+	"sync"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
 )
 
 var RelayCountCache = make(map[string]uint16)
+var relayCountCacheMutex sync.Mutex
 
-func GetRelayCount(ctx context.Context, addr string, conn net.Conn) (uint16, error) {
-	if count, ok := RelayCountCache[addr]; ok {
-		return count, nil
-	} else {
-		count, err := DiscoverRelayCount(ctx, addr, conn)
-		if err != nil {
-			return 0, err
-		}
-		RelayCountCache[addr] = count
+// GetRelayCount returns the device's configured relay count if it has one, and otherwise discovers it once and
+// caches the result.
+func GetRelayCount(ctx context.Context, conn *Conn) (uint16, error) {
+	device := conn.Device
+	if device.RelayCount > 0 {
+		return uint16(device.RelayCount), nil
+	}
+
+	key := fmt.Sprintf("%s@%d", device.Key(), device.CoilBase)
+	relayCountCacheMutex.Lock()
+	count, ok := RelayCountCache[key]
+	relayCountCacheMutex.Unlock()
+	if ok {
 		return count, nil
 	}
+
+	count, err := DiscoverRelayCount(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	relayCountCacheMutex.Lock()
+	RelayCountCache[key] = count
+	relayCountCacheMutex.Unlock()
+	return count, nil
 }
 
 // DiscoverRelayCount attempts to discover the number of relays (coils) on a Modbus device
-// by performing a binary search over the valid coil addresses (0 to 65535).
+// by performing a binary search over the valid coil addresses (the device's coil base to 65535).
 // It sends Read Coils requests and checks for Illegal Data Address errors to determine
 // the highest valid coil address, thus inferring the total number of relays.
-func DiscoverRelayCount(ctx context.Context, addr string, conn net.Conn) (uint16, error) {
-	low := uint16(0)
+func DiscoverRelayCount(ctx context.Context, conn *Conn) (uint16, error) {
+	addr := conn.Device.Address
+	base := uint16(conn.Device.CoilBase)
+	low := base
 	high := uint16(0xFFFF)
 
 	util.LogDebug(ctx, "Starting relay count discovery", "address", addr)
 	pass := 0
 	for low <= high {
-		mid := (low + high) / 2
+		mid := low + (high-low)/2
 
 		pass++
 		util.LogDebug(ctx, "Checking presence of relay", "address", addr, "relayIndex", mid)
@@ -48,6 +64,9 @@ func DiscoverRelayCount(ctx context.Context, addr string, conn net.Conn) (uint16
 			// Check if it's an Illegal Data Address error
 			if IsIllegalDataAddress(err) {
 				// mid was not valid, so continue the search in the lower half
+				if mid == base {
+					return 0, fmt.Errorf("no relays found at coil base %d", base)
+				}
 				high = mid - 1
 			} else {
 				return 0, fmt.Errorf("error during relay count discovery at address %d (pass %d): %w", mid, pass, err)
@@ -55,8 +74,8 @@ func DiscoverRelayCount(ctx context.Context, addr string, conn net.Conn) (uint16
 		}
 	}
 
-	util.LogDebug(ctx, "Discovered relay count", "actualCount", high+1, "requestsMade", pass)
-	return high + 1, nil
+	util.LogDebug(ctx, "Discovered relay count", "actualCount", high+1-base, "requestsMade", pass)
+	return high + 1 - base, nil
 }
 
 func IsIllegalDataAddress(err error) bool {
@@ -3,6 +3,7 @@ package modbus
 import (
 	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 
@@ -53,3 +54,75 @@ func ReadResponse(ctx context.Context, conn net.Conn) (*Response, error) {
 		Data:          payload,
 	}, nil
 }
+
+// ReadRTUResponse reads a Modbus RTU frame. RTU frames carry no length, so the expected size is derived from the
+// function code. The result is presented with an MBAP-style header matching the request, so it can be validated
+// the same way as a Modbus TCP response.
+func ReadRTUResponse(ctx context.Context, conn net.Conn, request *Message) (*Response, error) {
+	// unit ID and function code
+	var frame util.HexBytes = make([]byte, 2, 8)
+	_, err := io.ReadFull(conn, frame)
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining int
+	fc := frame[1]
+	switch {
+	case fc&0x80 != 0:
+		remaining = 1
+	case FunctionCode(fc) == ReadCoilsFunction, FunctionCode(fc) == ReadDiscreteInputsFunction:
+		byteCount := make([]byte, 1)
+		if _, err = io.ReadFull(conn, byteCount); err != nil {
+			return nil, err
+		}
+		frame = append(frame, byteCount[0])
+		remaining = int(byteCount[0])
+	case FunctionCode(fc) == WriteSingleCoilFunction, FunctionCode(fc) == WriteMultipleCoilsFunction:
+		remaining = 4
+	default:
+		return nil, fmt.Errorf("unexpected function code in RTU response: %02X", fc)
+	}
+
+	// the rest of the PDU, plus two bytes of CRC
+	rest := make([]byte, remaining+2)
+	if _, err = io.ReadFull(conn, rest); err != nil {
+		return nil, err
+	}
+	frame = append(frame, rest...)
+
+	util.LogDebug(ctx, "Response", "frame", frame)
+
+	body := frame[:len(frame)-2]
+	expectedCRC := crc16(body)
+	actualCRC := binary.LittleEndian.Uint16(frame[len(frame)-2:])
+	if expectedCRC != actualCRC {
+		return nil, fmt.Errorf("RTU response CRC mismatch: expected %04X, got %04X", expectedCRC, actualCRC)
+	}
+
+	return &Response{
+		MessageHeader: &MessageHeader{
+			TransactionID: request.Header.TransactionID,
+			ProtocolID:    request.Header.ProtocolID,
+			Length:        uint16(len(body)),
+			UnitID:        body[0],
+		},
+		Data: body[1:],
+	}, nil
+}
+
+// crc16 computes the Modbus RTU CRC (polynomial 0xA001, initial value 0xFFFF).
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
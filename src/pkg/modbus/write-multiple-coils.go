@@ -0,0 +1,75 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// MaxWriteMultipleCoils is the most coils one Write Multiple Coils request can set.
+const MaxWriteMultipleCoils = 1968
+
+// WriteMultipleCoils sets a range of coils in one request. Values are coil states, not logical relay states.
+type WriteMultipleCoils struct {
+	MessageHeader *MessageHeader
+	FunctionCode  byte
+	StartAddress  uint16
+	Values        []bool
+}
+
+func NewWriteMultipleCoils(startAddress uint16, values []bool) *WriteMultipleCoils {
+	return &WriteMultipleCoils{
+		FunctionCode: byte(WriteMultipleCoilsFunction),
+		StartAddress: startAddress,
+		Values:       values,
+	}
+}
+
+func (w *WriteMultipleCoils) ToDataBytes() util.HexBytes {
+	byteCount := (len(w.Values) + 7) / 8
+	msg := make([]byte, 6+byteCount)
+	msg[0] = w.FunctionCode
+	binary.BigEndian.PutUint16(msg[1:], w.StartAddress)
+	binary.BigEndian.PutUint16(msg[3:], uint16(len(w.Values)))
+	msg[5] = byte(byteCount)
+	for i, on := range w.Values {
+		if on {
+			msg[6+i/8] |= 1 << (i % 8)
+		}
+	}
+	return msg
+}
+
+// ValidateResponse checks that the response echoes the request's start address and quantity.
+func (w *WriteMultipleCoils) ValidateResponse(msg *Message, response *Response) error {
+	responseData := response.Data
+
+	validationErrors := make([]error, 0)
+	if response.MessageHeader.TransactionID != msg.Header.TransactionID {
+		validationErrors = append(validationErrors, fmt.Errorf("response transaction ID %x does not match request transaction ID %x", response.MessageHeader.TransactionID, msg.Header.TransactionID))
+	}
+	if err := validateFunctionCode(responseData[0], WriteMultipleCoilsFunction); err != nil {
+		validationErrors = append(validationErrors, err)
+	}
+	if len(responseData) != 5 {
+		validationErrors = append(validationErrors, fmt.Errorf("response data length is %d, expected 5", len(responseData)))
+	} else {
+		if address := binary.BigEndian.Uint16(responseData[1:]); address != w.StartAddress {
+			validationErrors = append(validationErrors, fmt.Errorf("response start address is %d, expected %d", address, w.StartAddress))
+		}
+		if quantity := binary.BigEndian.Uint16(responseData[3:]); int(quantity) != len(w.Values) {
+			validationErrors = append(validationErrors, fmt.Errorf("response quantity is %d, expected %d", quantity, len(w.Values)))
+		}
+	}
+
+	if len(validationErrors) == 0 {
+		return nil
+	}
+	return errors.Join(validationErrors...)
+}
+
+func (w *WriteMultipleCoils) ParseResponse(_ *Response) (interface{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,207 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a standard five-field cron expression: minute, hour, day of month, month and day of week. Fields accept
+// *, numbers, names (JAN-DEC, SUN-SAT), ranges (1-5), lists (1,15) and steps (*/15, 9-17/2). Day of week 0 and 7
+// are both Sunday. As in Vixie cron, if both day fields are restricted, a time matches if either one does.
+//
+// The macros @yearly (@annually), @monthly, @weekly, @daily (@midnight) and @hourly are also accepted.
+type Cron struct {
+	spec       string
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyHour    bool
+	anyDay     bool
+	anyWeekday bool
+}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12,
+		names: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func ParseCron(spec string) (*Cron, error) {
+	expanded := strings.TrimSpace(spec)
+	if macro, exists := cronMacros[strings.ToLower(expanded)]; exists {
+		expanded = macro
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields (minute hour day month weekday), not %d",
+			spec, len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression '%s': %w", cronFields[i].name, spec, err)
+		}
+		sets[i] = set
+	}
+	weekdays := sets[4]
+	if weekdays&(1<<7) != 0 {
+		weekdays |= 1
+	}
+	return &Cron{
+		spec:       spec,
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   weekdays,
+		anyHour:    strings.HasPrefix(fields[1], "*"),
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+		}
+
+		var low, high int
+		if rangePart == "*" {
+			low, high = f.min, f.max
+		} else {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = f.value(lowPart); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(highPart); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("range '%s' is backwards", rangePart)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+	if value < f.min || value > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", value, f.min, f.max)
+	}
+	return value, nil
+}
+
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// Next returns the first matching minute after t, in t's location. Wall-clock times skipped by a daylight saving
+// change never match. In an hour that a change repeats, expressions with a fixed hour match only the first time. It
+// returns the zero time if nothing matches within five years (e.g. "0 0 30 2 *").
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hours&(1<<t.Hour()) == 0 || (!c.anyHour && isRepeatedHour(t)) {
+			t = nextHour(t)
+			continue
+		}
+		if c.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	day := c.days&(1<<t.Day()) != 0
+	weekday := c.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// isRepeatedHour reports whether t is in the second pass through an hour that a daylight saving change repeats.
+func isRepeatedHour(t time.Time) bool {
+	earlier := t.Add(-time.Hour)
+	return earlier.Hour() == t.Hour() && earlier.Day() == t.Day()
+}
+
+// nextHour returns the start of the hour after t's. Adding elapsed time rather than rebuilding the wall-clock time
+// keeps this from looping when a daylight saving change repeats an hour.
+func nextHour(t time.Time) time.Time {
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
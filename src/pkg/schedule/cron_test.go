@@ -0,0 +1,86 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCronNext(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, newYork)
+	}
+	// 2025-11-02 01:00 happens twice in New York: first in EDT, then in EST
+	fallBack := time.Date(2025, time.November, 2, 5, 0, 0, 0, time.UTC).In(newYork)
+
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"*/15 * * * *", at(2025, time.June, 2, 10, 7), at(2025, time.June, 2, 10, 15)},
+		{"*/15 * * * *", at(2025, time.June, 2, 10, 15), at(2025, time.June, 2, 10, 30)},
+		{"0 9-17/2 * * *", at(2025, time.June, 2, 10, 0), at(2025, time.June, 2, 11, 0)},
+		{"0 9 * * mon-fri", at(2025, time.March, 7, 10, 0), at(2025, time.March, 10, 9, 0)},
+		{"0 12 * * 7", at(2025, time.March, 8, 12, 0), at(2025, time.March, 9, 12, 0)},
+		{"0 0 1 JAN,jul *", at(2025, time.February, 1, 0, 0), at(2025, time.July, 1, 0, 0)},
+		{"@daily", at(2025, time.June, 2, 10, 7), at(2025, time.June, 3, 0, 0)},
+		{"@hourly", at(2025, time.June, 2, 10, 7), at(2025, time.June, 2, 11, 0)},
+		{"@weekly", at(2025, time.June, 2, 10, 7), at(2025, time.June, 8, 0, 0)},
+		{"@monthly", at(2025, time.June, 2, 10, 7), at(2025, time.July, 1, 0, 0)},
+		{"@yearly", at(2025, time.June, 2, 10, 7), at(2026, time.January, 1, 0, 0)},
+		// with both day fields restricted, either matches: Friday the 6th comes before the 13th
+		{"0 0 13 * fri", at(2025, time.June, 1, 0, 0), at(2025, time.June, 6, 0, 0)},
+		{"0 0 13 * *", at(2025, time.June, 1, 0, 0), at(2025, time.June, 13, 0, 0)},
+		{"0 0 * * fri", at(2025, time.June, 1, 0, 0), at(2025, time.June, 6, 0, 0)},
+		// 02:30 doesn't exist on 2025-03-09 in New York
+		{"30 2 * * *", at(2025, time.March, 8, 12, 0), at(2025, time.March, 10, 2, 30)},
+		{"*/30 * * * *", at(2025, time.March, 9, 1, 45), at(2025, time.March, 9, 3, 0)},
+		// a fixed time in the repeated hour runs only the first time
+		{"30 1 * * *", at(2025, time.November, 1, 12, 0), fallBack.Add(30 * time.Minute)},
+		{"30 1 * * *", fallBack.Add(30 * time.Minute), at(2025, time.November, 3, 1, 30)},
+		// but an hourly schedule runs in both
+		{"*/30 * * * *", fallBack.Add(45 * time.Minute), fallBack.Add(time.Hour)},
+		{"0 0 30 2 *", at(2025, time.June, 2, 10, 7), time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := ParseCron(test.spec)
+		if err != nil {
+			t.Errorf("%s: %v", test.spec, err)
+			continue
+		}
+		if actual := cron.Next(test.from); !actual.Equal(test.expected) {
+			t.Errorf("%s after %s: got %s, expected %s", test.spec, test.from, actual, test.expected)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"0 0 * foo *",
+		"@reboot",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package schedule parses the expressions that say when scheduled programs run.
+package schedule
+
+import "time"
+
+// Trigger yields the times at which a schedule fires.
+type Trigger interface {
+	// Next returns the first fire time after t, in t's location, or the zero time if there is none.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Parse parses a schedule expression: a cron expression, or a solar one like "sunset-30m", which needs a site.
+func Parse(spec string, site *Site) (Trigger, error) {
+	solar, isSolar, err := parseSolar(spec, site)
+	if err != nil {
+		return nil, err
+	}
+	if isSolar {
+		return solar, nil
+	}
+	return ParseCron(spec)
+}
+
+// Validate checks a schedule expression's syntax, without needing a site for solar expressions.
+func Validate(spec string) error {
+	_, err := Parse(spec, &Site{})
+	return err
+}
@@ -0,0 +1,172 @@
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Site is the place for which sunrise and sunset are computed. Latitude is positive north and longitude positive
+// east, both in decimal degrees.
+type Site struct {
+	Latitude  float64 `json:"latitude" example:"42.96"`
+	Longitude float64 `json:"longitude" example:"-85.67"`
+}
+
+func (s *Site) Validate() error {
+	if s.Latitude < -90 || s.Latitude > 90 {
+		return fmt.Errorf("latitude %g is out of range -90 to 90", s.Latitude)
+	}
+	if s.Longitude < -180 || s.Longitude > 180 {
+		return fmt.Errorf("longitude %g is out of range -180 to 180", s.Longitude)
+	}
+	return nil
+}
+
+type SolarEvent string
+
+const (
+	Sunrise SolarEvent = "sunrise"
+	Sunset  SolarEvent = "sunset"
+)
+
+// Solar fires at sunrise or sunset, plus an offset, e.g. "sunset-30m" or "sunrise+1h15m". Times are computed with
+// NOAA's solar equations, which are accurate to about a minute away from the poles. On days when the sun doesn't rise
+// or set at the site, it doesn't fire.
+type Solar struct {
+	Event  SolarEvent
+	Offset time.Duration
+	site   *Site
+	spec   string
+}
+
+// parseSolar parses a solar expression. ok is false if spec isn't one.
+func parseSolar(spec string, site *Site) (solar *Solar, ok bool, err error) {
+	trimmed := strings.ToLower(strings.TrimSpace(spec))
+	var event SolarEvent
+	for _, candidate := range []SolarEvent{Sunrise, Sunset} {
+		if strings.HasPrefix(trimmed, string(candidate)) {
+			event = candidate
+		}
+	}
+	if event == "" {
+		return nil, false, nil
+	}
+
+	var offset time.Duration
+	if rest := strings.TrimSpace(strings.TrimPrefix(trimmed, string(event))); rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return nil, true, fmt.Errorf("invalid solar schedule '%s': expected %s+<duration> or %s-<duration>",
+				spec, event, event)
+		}
+		offset, err = time.ParseDuration(strings.ReplaceAll(rest, " ", ""))
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid offset in solar schedule '%s': %w", spec, err)
+		}
+		if offset.Abs() >= 12*time.Hour {
+			return nil, true, fmt.Errorf("offset in solar schedule '%s' must be less than 12 hours", spec)
+		}
+	}
+
+	if site == nil {
+		return nil, true, fmt.Errorf("solar schedule '%s' needs a latitude and longitude", spec)
+	}
+	if err := site.Validate(); err != nil {
+		return nil, true, err
+	}
+	return &Solar{Event: event, Offset: offset, site: site, spec: spec}, true, nil
+}
+
+func (s *Solar) String() string {
+	return s.spec
+}
+
+// Next returns the first sunrise or sunset, plus the offset, after t.
+func (s *Solar) Next(t time.Time) time.Time {
+	loc := t.Location()
+	year, month, day := t.Date()
+	// start a day early, since the offset can move yesterday's event past t
+	for i := -1; i <= 366; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, time.UTC)
+		event, ok := s.site.eventOn(date, s.Event)
+		if !ok {
+			continue
+		}
+		if fire := event.Add(s.Offset).Truncate(time.Second); fire.After(t) {
+			return fire.In(loc)
+		}
+	}
+	return time.Time{}
+}
+
+// eventOn returns the time of sunrise or sunset on the given date, which is read in UTC. ok is false if the sun
+// doesn't rise or set that day.
+func (s *Site) eventOn(date time.Time, event SolarEvent) (time.Time, bool) {
+	julianDay := float64(date.Unix())/86400 + 2440587.5
+
+	// NOAA's method: estimate the time at local solar noon, then refine once with the sun's position at that time.
+	minutes, ok := s.eventMinutesUTC(julianDay+0.5-s.Longitude/360, event)
+	if !ok {
+		return time.Time{}, false
+	}
+	minutes, ok = s.eventMinutesUTC(julianDay+minutes/1440, event)
+	if !ok {
+		return time.Time{}, false
+	}
+	return date.Add(time.Duration(minutes * float64(time.Minute))), true
+}
+
+// eventMinutesUTC returns the event's time, in minutes after midnight UTC on the Julian day's date, using the sun's
+// position at julianDay.
+func (s *Site) eventMinutesUTC(julianDay float64, event SolarEvent) (float64, bool) {
+	t := (julianDay - 2451545) / 36525
+	declination, equationOfTime := sunPosition(t)
+
+	// 90.833° allows for atmospheric refraction and the size of the sun's disc
+	zenith := radians(90.833)
+	latitude := radians(s.Latitude)
+	cosHourAngle := math.Cos(zenith)/(math.Cos(latitude)*math.Cos(declination)) - math.Tan(latitude)*math.Tan(declination)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return 0, false
+	}
+	hourAngle := degrees(math.Acos(cosHourAngle))
+	if event == Sunset {
+		hourAngle = -hourAngle
+	}
+	return 720 - 4*(s.Longitude+hourAngle) - equationOfTime, true
+}
+
+// sunPosition returns the sun's declination, in radians, and the equation of time, in minutes, at t Julian centuries
+// since J2000.
+func sunPosition(t float64) (declination float64, equationOfTime float64) {
+	meanLongitude := radians(math.Mod(280.46646+t*(36000.76983+t*0.0003032), 360))
+	meanAnomaly := radians(357.52911 + t*(35999.05029-0.0001537*t))
+	eccentricity := 0.016708634 - t*(0.000042037+0.0000001267*t)
+
+	center := math.Sin(meanAnomaly)*(1.914602-t*(0.004817+0.000014*t)) +
+		math.Sin(2*meanAnomaly)*(0.019993-0.000101*t) +
+		math.Sin(3*meanAnomaly)*0.000289
+	omega := radians(125.04 - 1934.136*t)
+	apparentLongitude := radians(degrees(meanLongitude) + center - 0.00569 - 0.00478*math.Sin(omega))
+
+	meanObliquity := 23 + (26+(21.448-t*(46.815+t*(0.00059-t*0.001813)))/60)/60
+	obliquity := radians(meanObliquity + 0.00256*math.Cos(omega))
+	declination = math.Asin(math.Sin(obliquity) * math.Sin(apparentLongitude))
+
+	y := math.Pow(math.Tan(obliquity/2), 2)
+	equationOfTime = 4 * degrees(y*math.Sin(2*meanLongitude)-
+		2*eccentricity*math.Sin(meanAnomaly)+
+		4*eccentricity*y*math.Sin(meanAnomaly)*math.Cos(2*meanLongitude)-
+		0.5*y*y*math.Sin(4*meanLongitude)-
+		1.25*eccentricity*eccentricity*math.Sin(2*meanAnomaly))
+	return declination, equationOfTime
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
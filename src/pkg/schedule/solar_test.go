@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// grandRapids is the site in the README's examples.
+var grandRapids = &Site{Latitude: 42.96, Longitude: -85.67}
+
+func TestSolarNext(t *testing.T) {
+	detroit := mustLoad(t, "America/Detroit")
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, detroit)
+	}
+
+	// published times, to the minute; NOAA's equations are accurate to about a minute
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"sunrise", at(2025, time.March, 20, 0, 0), at(2025, time.March, 20, 7, 45)},
+		{"sunset", at(2025, time.March, 20, 0, 0), at(2025, time.March, 20, 19, 55)},
+		{"sunrise", at(2025, time.June, 21, 0, 0), at(2025, time.June, 21, 6, 4)},
+		{"sunset", at(2025, time.June, 21, 0, 0), at(2025, time.June, 21, 21, 25)},
+		{"sunrise", at(2025, time.December, 21, 0, 0), at(2025, time.December, 21, 8, 11)},
+		{"sunset", at(2025, time.December, 21, 0, 0), at(2025, time.December, 21, 17, 11)},
+		// once today's has passed, the next is tomorrow's
+		{"sunrise", at(2025, time.June, 21, 12, 0), at(2025, time.June, 22, 6, 4)},
+		{"sunset-30m", at(2025, time.June, 21, 12, 0), at(2025, time.June, 21, 20, 55)},
+		{"sunset-30m", at(2025, time.June, 21, 21, 0), at(2025, time.June, 22, 20, 55)},
+		{"sunrise+1h15m", at(2025, time.December, 21, 0, 0), at(2025, time.December, 21, 9, 26)},
+		// an offset can move yesterday's event past midnight
+		{"sunset+5h", at(2025, time.June, 22, 0, 0), at(2025, time.June, 22, 2, 25)},
+	}
+	for _, test := range tests {
+		trigger, err := Parse(test.spec, grandRapids)
+		if err != nil {
+			t.Errorf("%s: %v", test.spec, err)
+			continue
+		}
+		actual := trigger.Next(test.from)
+		if diff := actual.Sub(test.expected).Abs(); diff > time.Minute {
+			t.Errorf("%s after %s: got %s, expected %s", test.spec, test.from, actual, test.expected)
+		}
+		if actual.Location() != detroit {
+			t.Errorf("%s: got a time in %s, expected one in %s", test.spec, actual.Location(), detroit)
+		}
+	}
+}
+
+func TestSolarSkipsDaysWithoutEvent(t *testing.T) {
+	// in Longyearbyen the sun doesn't set from April until late August
+	longyearbyen := &Site{Latitude: 78.22, Longitude: 15.65}
+	trigger, err := Parse("sunset", longyearbyen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := trigger.Next(time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC))
+	if actual.Month() != time.August || actual.Day() < 23 || actual.Day() > 25 {
+		t.Errorf("got %s, expected the first sunset in late August", actual)
+	}
+}
+
+func TestParseSolarErrors(t *testing.T) {
+	for _, spec := range []string{"sunset 30m", "sunrise+", "sunset+1x", "sunset-12h", "sunrise+13h"} {
+		if _, err := Parse(spec, grandRapids); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+	if _, err := Parse("sunset", nil); err == nil {
+		t.Error("expected an error for a solar schedule without a site")
+	}
+	if _, err := Parse("sunset", &Site{Latitude: 91}); err == nil {
+		t.Error("expected an error for an invalid latitude")
+	}
+}
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleLocks godoc
+// @Summary      List relay locks
+// @Description  Returns which program owns each relay, followed by the programs queued for relays, in the order
+// @Description  they will run. A lock's `state` is `held`, `waiting`, or `suspended` (by a program of higher priority).
+// @Tags         run
+// @Produce      json
+// @Success      200 {array} api.Lock
+// @Failure      500 {object} server.ErrorResponse
+// @Router       /locks [get]
+func (server *Server) handleLocks(w http.ResponseWriter, r *http.Request) {
+	server.respondWithJSON(r.Context(), w, http.StatusOK, server.Arbiter.Locks())
+}
@@ -17,9 +17,11 @@ type ProgramsBySlugExample struct {
 
 // handlePrograms godoc
 // @Summary      List known programs
-// @Description  Returns all available programs keyed by slug
+// @Description  Returns all available programs keyed by slug. Pattern commands, like `chase`, are shown as written,
+// @Description  unless `expanded=true`, which shows the command groups they generate instead.
 // @Tags         programs
 // @Produce      json
+// @Param        expanded query bool false "Show the command groups generated by pattern commands"
 // @Success      200 {object} ProgramsBySlugExample
 // @Failure      500 {object} server.ErrorResponse
 // @Router       /programs [get]
@@ -28,7 +30,13 @@ func (server *Server) handlePrograms(w http.ResponseWriter, r *http.Request) {
 	encoder := json.NewEncoder(w)
 	ctx := r.Context()
 	server.Registry.LoadProgramsFromDir(ctx, server.ProgramDir)
-	err := encoder.Encode(server.Registry.Programs)
+	programs := server.Registry.CopyPrograms()
+	if r.URL.Query().Get("expanded") != "true" {
+		for slug, program := range programs {
+			programs[slug] = program.Compact()
+		}
+	}
+	err := encoder.Encode(programs)
 	if err != nil {
 		server.RespondWithError(ctx, w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode programs: %v", err))
 	}
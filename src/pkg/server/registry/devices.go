@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/api"
+	"github.com/jakerobb/modbus-eth-controller/pkg/modbus"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// LoadDevicesFromFile reads named device definitions. A missing file is not an error; every program address is
+// then treated as a raw address.
+func (r *Registry) LoadDevicesFromFile(ctx context.Context, path string) {
+	logger := util.GetLogger(ctx)
+
+	r.mutex.Lock()
+	r.DevicesPath = path
+	r.mutex.Unlock()
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("No devices file found. Program addresses will be used as-is.", "path", path)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to stat devices file. No devices will be loaded.", "path", path, "error", err)
+		return
+	}
+
+	r.loadDevices(ctx, path, info.ModTime())
+}
+
+// devicesCheckInterval limits how often the devices file is checked for changes, since devices are resolved many
+// times for each command group.
+const devicesCheckInterval = time.Second
+
+// ReloadDevicesFromDiskIfNewer re-reads the devices file if it has changed since it was last loaded. It checks at
+// most once every devicesCheckInterval, and warns once if the file can't be read, until it can be again.
+func (r *Registry) ReloadDevicesFromDiskIfNewer(ctx context.Context) {
+	r.mutex.Lock()
+	path := r.DevicesPath
+	lastModified := r.devicesLastModified
+	due := time.Since(r.devicesCheckedAt) >= devicesCheckInterval
+	if due {
+		r.devicesCheckedAt = time.Now()
+	}
+	r.mutex.Unlock()
+
+	if path == "" || !due {
+		return
+	}
+
+	info, err := os.Stat(path)
+	r.mutex.Lock()
+	warned := r.devicesStatFailed
+	r.devicesStatFailed = err != nil
+	r.mutex.Unlock()
+	if err != nil {
+		if !warned && (!errors.Is(err, os.ErrNotExist) || !lastModified.IsZero()) {
+			util.GetLogger(ctx).Warn("Failed to stat devices file. Keeping previously loaded devices.", "path", path, "error", err)
+		}
+		return
+	}
+	if info.ModTime().After(lastModified) {
+		r.loadDevices(ctx, path, info.ModTime())
+	}
+}
+
+func (r *Registry) loadDevices(ctx context.Context, path string, modTime time.Time) {
+	logger := util.GetLogger(ctx)
+	devices, err := api.ParseDevicesFromFile(path)
+	if err != nil {
+		logger.Error("Failed to parse devices file. Keeping previously loaded devices.", "path", path, "error", err)
+		return
+	}
+
+	r.mutex.Lock()
+	r.Devices = devices
+	r.devicesLastModified = modTime
+	r.mutex.Unlock()
+
+	for name, device := range devices {
+		logger.Info("Loaded device",
+			"name", name,
+			"address", device.Address)
+	}
+	logger.Info("Loaded devices",
+		"deviceCount", len(devices),
+		"path", path)
+}
+
+func (r *Registry) GetDevice(name string) (*modbus.Device, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	device, exists := r.Devices[name]
+	return device, exists
+}
+
+// ResolveDevice returns the named device, reloading the devices file first if it has changed. Unknown names are
+// treated as raw addresses.
+func (r *Registry) ResolveDevice(ctx context.Context, nameOrAddress string) *modbus.Device {
+	r.ReloadDevicesFromDiskIfNewer(ctx)
+	if device, exists := r.GetDevice(nameOrAddress); exists {
+		return device
+	}
+	return modbus.NewDevice(nameOrAddress)
+}
+
+func (r *Registry) isDevicesFile(path string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.DevicesPath != "" && sameFile(r.DevicesPath, path)
+}
+
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
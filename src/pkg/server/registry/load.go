@@ -2,10 +2,14 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/api"
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
@@ -23,6 +27,9 @@ func (r *Registry) LoadProgramsFromDir(ctx context.Context, dir string) {
 			continue
 		}
 		fullPath := filepath.Join(dir, file.Name())
+		if r.isConfigFile(fullPath) {
+			continue
+		}
 		program, err := api.ParseProgramFromFile(fullPath)
 		if err != nil {
 			logger.Error("Failed to parse program from file. Skipping.", "file", fullPath, "error", err)
@@ -43,6 +50,61 @@ func (r *Registry) LoadProgramsFromDir(ctx context.Context, dir string) {
 	}
 	logger.Info("Loaded programs",
 		"programCount", r.Size())
+	r.checkRuns(ctx)
+}
+
+// checkRuns logs programs whose run commands call programs that don't exist, and cycles of run commands. Such
+// programs fail validation, so they won't run until they are fixed.
+func (r *Registry) checkRuns(ctx context.Context) {
+	logger := util.GetLogger(ctx)
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, slug := range slices.Sorted(maps.Keys(r.Programs)) {
+		for _, called := range r.Programs[slug].RunSlugs() {
+			if _, exists := r.Programs[called]; !exists {
+				logger.Warn("Program runs a program that doesn't exist.", "slug", slug, "missing", called)
+			}
+		}
+	}
+	for _, cycle := range r.runCycles() {
+		logger.Error("Program calls itself through run commands. It won't run.",
+			"slug", cycle[0], "cycle", strings.Join(cycle, " -> "))
+	}
+}
+
+// runCycles returns cycles of run commands, each starting and ending with a program in it. Each program is visited
+// once, so a cycle is reported once, though cycles that share programs with one already found may not be reported
+// until it is fixed.
+func (r *Registry) runCycles() [][]string {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	cycles := make([][]string, 0)
+	var visit func(path []string)
+	visit = func(path []string) {
+		slug := path[len(path)-1]
+		state[slug] = visiting
+		if program, exists := r.Programs[slug]; exists {
+			for _, called := range program.RunSlugs() {
+				switch state[called] {
+				case visiting:
+					i := slices.Index(path, called)
+					cycles = append(cycles, append(slices.Clone(path[i:]), called))
+				case 0:
+					visit(append(path, called))
+				}
+			}
+		}
+		state[slug] = visited
+	}
+	for _, slug := range slices.Sorted(maps.Keys(r.Programs)) {
+		if state[slug] == 0 {
+			visit([]string{slug})
+		}
+	}
+	return cycles
 }
 
 func (r *Registry) LoadNewProgramFromDisk(ctx context.Context, slug string, dir string) (*api.Program, error) {
@@ -63,6 +125,9 @@ func (r *Registry) LoadNewProgramFromDisk(ctx context.Context, slug string, dir
 			continue
 		}
 		fullPath := filepath.Join(dir, file.Name())
+		if r.isConfigFile(fullPath) {
+			continue
+		}
 		program, err := api.ParseProgramFromFile(fullPath)
 		if err != nil {
 			logger.Error("Failed to parse program from file. Skipping.", "file", fullPath, "error", err)
@@ -107,3 +172,89 @@ func (r *Registry) ReloadProgramFromDiskIfNewer(ctx context.Context, program *ap
 	}
 	return program, nil
 }
+
+// RefreshProgramsFromDir loads programs added to dir and reloads those that have changed since they were last
+// loaded, forgets those whose files have been deleted, and returns every stored program. Unlike LoadProgramsFromDir,
+// it logs nothing for unchanged programs, and logs a file that fails to load once until it changes, so it can be
+// called often.
+func (r *Registry) RefreshProgramsFromDir(ctx context.Context, dir string) []*api.Program {
+	logger := util.GetLogger(ctx)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("Failed to read program directory.", "dir", dir, "error", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		fullPath := filepath.Join(dir, file.Name())
+		if r.isConfigFile(fullPath) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || r.failedUnchanged(fullPath, info.ModTime()) {
+			continue
+		}
+		slug := api.SlugifyFilename(fullPath)
+		program, exists := r.GetProgram(slug)
+		if !exists {
+			var loaded *api.Program
+			if loaded, err = r.LoadNewProgramFromDisk(ctx, slug, dir); err == nil && loaded == nil {
+				// LoadNewProgramFromDisk has logged why
+				r.setFailed(fullPath, info.ModTime())
+				continue
+			}
+		} else if program.Path == fullPath {
+			_, err = r.ReloadProgramFromDiskIfNewer(ctx, program)
+		}
+		if err != nil {
+			logger.Error("Failed to load program. Skipping.", "file", fullPath, "error", err)
+			r.setFailed(fullPath, info.ModTime())
+			continue
+		}
+		r.setFailed(fullPath, time.Time{})
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	programs := make([]*api.Program, 0, len(r.Programs))
+	for path := range r.failedPrograms {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			delete(r.failedPrograms, path)
+		}
+	}
+	for slug, program := range r.Programs {
+		if _, err := os.Stat(program.Path); errors.Is(err, os.ErrNotExist) {
+			delete(r.Programs, slug)
+			logger.Info("Forgot deleted program", "slug", slug, "path", program.Path)
+			continue
+		}
+		programs = append(programs, program)
+	}
+	return programs
+}
+
+// failedUnchanged reports whether the program file at path failed to load, and hasn't changed since.
+func (r *Registry) failedUnchanged(path string, modTime time.Time) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	failed, exists := r.failedPrograms[path]
+	return exists && failed.Equal(modTime)
+}
+
+// setFailed records that the program file at path failed to load at modTime, or, if modTime is zero, that it loaded.
+func (r *Registry) setFailed(path string, modTime time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if modTime.IsZero() {
+		delete(r.failedPrograms, path)
+	} else {
+		r.failedPrograms[path] = modTime
+	}
+}
+
+// isConfigFile reports whether path is the devices or schedules file, which live alongside programs.
+func (r *Registry) isConfigFile(path string) bool {
+	return r.isDevicesFile(path) || r.isSchedulesFile(path)
+}
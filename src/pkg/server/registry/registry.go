@@ -1,20 +1,36 @@
 package registry
 
 import (
+	"maps"
 	"sync"
+	"time"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/api"
 )
 
 type Registry struct {
-	Programs map[string]*api.Program `json:"status"`
-	mutex    sync.RWMutex
+	Programs              map[string]*api.Program `json:"status"`
+	Devices               api.Devices             `json:"devices"`
+	DevicesPath           string                  `json:"devicesPath"`
+	Schedules             Schedules               `json:"schedules"`
+	SchedulesPath         string                  `json:"schedulesPath"`
+	devicesLastModified   time.Time
+	devicesCheckedAt      time.Time
+	devicesStatFailed     bool
+	schedulesLastModified time.Time
+	// failedPrograms holds the modification time of each program file that failed to load, by path, so that a
+	// failure is logged once per change to the file rather than on every refresh.
+	failedPrograms map[string]time.Time
+	mutex          sync.RWMutex
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
-		Programs: make(map[string]*api.Program),
-		mutex:    sync.RWMutex{},
+		Programs:       make(map[string]*api.Program),
+		Devices:        make(api.Devices),
+		Schedules:      make(Schedules),
+		failedPrograms: make(map[string]time.Time),
+		mutex:          sync.RWMutex{},
 	}
 }
 
@@ -31,6 +47,13 @@ func (r *Registry) StoreProgram(program *api.Program) {
 	r.Programs[program.Slug] = program
 }
 
+// CopyPrograms returns a copy of the stored programs by slug, which is safe to use while programs are being loaded.
+func (r *Registry) CopyPrograms() map[string]*api.Program {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return maps.Clone(r.Programs)
+}
+
 func (r *Registry) Size() int {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
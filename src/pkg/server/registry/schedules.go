@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/schedule"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// ScheduleDefinition is an entry in the schedules file: stored programs to run, in order, whenever the schedule
+// fires.
+type ScheduleDefinition struct {
+	Schedule string   `json:"schedule" example:"0 17 * * *"`
+	Programs []string `json:"programs" example:"porch-lights-on"`
+}
+
+// Schedules are the entries of a schedules file, by name.
+type Schedules map[string]*ScheduleDefinition
+
+func ParseSchedulesFromFile(path string) (Schedules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schedules Schedules
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON schedules: %w", err)
+	}
+	for name, definition := range schedules {
+		if definition == nil {
+			return nil, fmt.Errorf("schedule '%s' is empty", name)
+		}
+		if err := schedule.Validate(definition.Schedule); err != nil {
+			return nil, fmt.Errorf("schedule '%s' is invalid: %w", name, err)
+		}
+		if len(definition.Programs) == 0 {
+			return nil, fmt.Errorf("schedule '%s' has no programs", name)
+		}
+	}
+	return schedules, nil
+}
+
+// LoadSchedulesFromFile reads named schedules. A missing file is not an error; only schedules defined on programs
+// are then used.
+func (r *Registry) LoadSchedulesFromFile(ctx context.Context, path string) {
+	logger := util.GetLogger(ctx)
+
+	r.mutex.Lock()
+	r.SchedulesPath = path
+	r.mutex.Unlock()
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("No schedules file found. Only program schedules will be used.", "path", path)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to stat schedules file. No schedules will be loaded.", "path", path, "error", err)
+		return
+	}
+
+	r.loadSchedules(ctx, path, info.ModTime())
+}
+
+// ReloadSchedulesFromDiskIfNewer re-reads the schedules file if it has changed since it was last loaded.
+func (r *Registry) ReloadSchedulesFromDiskIfNewer(ctx context.Context) {
+	r.mutex.RLock()
+	path := r.SchedulesPath
+	lastModified := r.schedulesLastModified
+	r.mutex.RUnlock()
+
+	if path == "" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !lastModified.IsZero() {
+			util.GetLogger(ctx).Warn("Failed to stat schedules file. Keeping previously loaded schedules.", "path", path, "error", err)
+		}
+		return
+	}
+	if info.ModTime().After(lastModified) {
+		r.loadSchedules(ctx, path, info.ModTime())
+	}
+}
+
+func (r *Registry) loadSchedules(ctx context.Context, path string, modTime time.Time) {
+	logger := util.GetLogger(ctx)
+	schedules, err := ParseSchedulesFromFile(path)
+	if err != nil {
+		logger.Error("Failed to parse schedules file. Keeping previously loaded schedules.", "path", path, "error", err)
+		return
+	}
+
+	r.mutex.Lock()
+	r.Schedules = schedules
+	r.schedulesLastModified = modTime
+	r.mutex.Unlock()
+
+	for name, definition := range schedules {
+		logger.Info("Loaded schedule",
+			"name", name,
+			"schedule", definition.Schedule,
+			"programs", definition.Programs)
+	}
+	logger.Info("Loaded schedules",
+		"scheduleCount", len(schedules),
+		"path", path)
+}
+
+// GetSchedules returns the schedules defined in the schedules file.
+func (r *Registry) GetSchedules() Schedules {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.Schedules
+}
+
+func (r *Registry) isSchedulesFile(path string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.SchedulesPath != "" && sameFile(r.SchedulesPath, path)
+}
@@ -1,11 +1,15 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/jakerobb/modbus-eth-controller/pkg/api"
@@ -17,6 +21,11 @@ type RunStatus string
 
 var errorStatus RunStatus = "error"
 var successStatus RunStatus = "success"
+var cancelledStatus RunStatus = "cancelled"
+var runningStatus RunStatus = "running"
+var rejectedStatus RunStatus = "rejected"
+var preemptedStatus RunStatus = "preempted"
+var skippedStatus RunStatus = "skipped"
 
 type RunResponse struct {
 	Results []ProgramResult               `json:"results"`
@@ -24,12 +33,18 @@ type RunResponse struct {
 }
 
 type ProgramResult struct {
-	Status              *RunStatus   `json:"status" example:"success"`
-	Error               *string      `json:"error,omitempty" example:"relay 1 timed out"`
-	StartTime           *time.Time   `json:"startTime" example:"2025-01-01T12:00:00Z"`
-	ExecutionTimeMillis *int64       `json:"executionTimeMillis" example:"153"`
-	Slug                string       `json:"slug" example:"doorbell"`
-	Program             *api.Program `json:"program"`
+	Status              *RunStatus      `json:"status" example:"success"`
+	Error               *string         `json:"error,omitempty" example:"relay 1 timed out"`
+	SkipReason          *string         `json:"skipReason,omitempty" example:"debounced: triggered again 180 ms after the previous trigger (debounceMillis 2000)"`
+	StartTime           *time.Time      `json:"startTime" example:"2025-01-01T12:00:00Z"`
+	ExecutionTimeMillis *int64          `json:"executionTimeMillis" example:"153"`
+	Slug                string          `json:"slug" example:"doorbell"`
+	Program             *api.Program    `json:"program"`
+	Execution           *api.Execution  `json:"execution,omitempty"`
+	StateBefore         api.RelayStates `json:"stateBefore,omitempty"`
+	StateAfter          api.RelayStates `json:"stateAfter,omitempty"`
+	CalledFrom          string          `json:"calledFrom,omitempty" example:"loop 1, command group 2"`
+	Subprograms         []ProgramResult `json:"subprograms,omitempty"`
 }
 
 // handleRun godoc
@@ -38,21 +53,42 @@ type ProgramResult struct {
 // @Description  1. A program in the request body
 // @Description  2. Program slug(s) via the `program` query parameter
 // @Description  3. Both — the body program runs first, then the slugged programs in order
+// @Description
+// @Description  Groups are separated by `commandIntervalMillis`, unless a group contains a `wait` command, e.g.
+// @Description  `[{"command": "on", "relay": 7}, {"command": "wait", "durationMillis": 150}]`
+// @Description
+// @Description  Programs take turns on each relay; programs using different relays run at the same time. A program
+// @Description  with a higher `priority` suspends conflicting programs of lower priority, which resume afterwards. A
+// @Description  program's `onConflict` policy says what happens when another program of the same or higher priority is
+// @Description  using one of its relays: `queue` (the default) waits, `reject` fails with status `rejected`, and
+// @Description  `preempt` cancels the other program and runs once its `finally` groups have finished.
+// @Description
+// @Description  Triggers of stored programs with `debounceMillis`, `cooldownMillis` or `maxRunsPerMinute` may be
+// @Description  skipped. Skipped programs are reported with status `skipped` and a `skipReason`.
+// @Description
+// @Description  Programs that declare `parameters` take their values from other query parameters, e.g.
+// @Description  `/run?program=pulse&relay=3&length=1.5s`, or from a form-encoded body. Parameters without a value
+// @Description  take their defaults.
+// @Description
+// @Description  With `async=true`, responds immediately with the new run; poll `/runs/{id}` for progress and results.
 // @Tags         run
 // @Accept       json
 // @Produce      json
 // @Param        program query []string false "Program slug (repeatable)" collectionFormat(multi)
+// @Param        async query bool false "Respond immediately instead of waiting for the programs to finish"
 // @Param        program body api.Program false "Inline program to run"
 // @Success      200 {object} server.RunResponse
-// @Failure      400 {object} server.ErrorResponse "if the request body is malformed"
+// @Success      202 {object} server.Run "if async=true"
+// @Failure      400 {object} server.ErrorResponse "if the request body is malformed or a program is invalid"
 // @Failure      404 {object} server.ErrorResponse "if a named program is not found"
+// @Failure      409 {object} server.RunResponse "if a program with onConflict=reject found its relays in use"
 // @Failure      500 {object} server.ErrorResponse
 // @Router       /run [post]
 func (server *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 	var err error
 	programs := make([]*api.Program, 0)
 	ignoreBody := false
-	ctx := r.Context()
+	ctx := server.programContext(r.Context())
 
 	query := r.URL.Query()
 	debugParam := query.Get("debug")
@@ -63,6 +99,7 @@ func (server *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 	if ignoreBodyParam == "true" {
 		ignoreBody = true
 	}
+	values := parameterValues(query)
 
 	if !ignoreBody {
 		body, err := io.ReadAll(r.Body)
@@ -72,7 +109,18 @@ func (server *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 		}
 		defer util.CloseQuietly(r.Body)
 
-		if len(body) > 0 {
+		if isFormBody(r, body) {
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				server.RespondWithError(ctx, w, http.StatusBadRequest, fmt.Sprintf("Failed to parse parameters: %v", err))
+				return
+			}
+			for name, value := range parameterValues(form) {
+				if _, exists := values[name]; !exists {
+					values[name] = value
+				}
+			}
+		} else if len(body) > 0 {
 			program, err := api.ParseProgram(body)
 			if err != nil {
 				server.RespondWithError(ctx, w, http.StatusBadRequest, fmt.Sprintf("Failed to parse program: %v", err))
@@ -98,17 +146,46 @@ func (server *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, servers := server.runPrograms(ctx, programs)
+	for i, program := range programs {
+		if programs[i], err = program.Bind(values); err != nil {
+			server.RespondWithError(ctx, w, http.StatusBadRequest, fmt.Sprintf("Invalid program '%s': %v", program.Slug, err))
+			return
+		}
+		program = programs[i]
+		if err = program.Validate(ctx); err != nil {
+			server.RespondWithError(ctx, w, http.StatusBadRequest, fmt.Sprintf("Invalid program '%s': %v", program.Slug, err))
+			return
+		}
+	}
 
-	relayStatesByServer := server.collectRelayStates(ctx, servers)
+	now := time.Now()
+	skips := make([]string, len(programs))
+	for i, program := range programs {
+		skips[i] = server.Throttle.Check(program, now)
+	}
 
-	runResponse := RunResponse{
-		Results: results,
-		Status:  relayStatesByServer,
+	if query.Get("async") == "true" {
+		// Async runs outlive the request; they're cancelled via DELETE /runs/{id} or when the server shuts down.
+		run := server.Runs.Start(context.WithoutCancel(ctx), server, requestTrigger, programs, skips)
+		w.Header().Set("Location", "/runs/"+run.ID)
+		server.respondWithJSON(ctx, w, http.StatusAccepted, run)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	run := server.Runs.Start(ctx, server, requestTrigger, programs, skips)
+	<-run.Done()
+
+	run.mutex.Lock()
+	runResponse := run.RunResponse
+	state := run.State
+	run.mutex.Unlock()
+
 	w.Header().Set("Content-Type", "application/json")
+	if state == rejectedStatus {
+		w.WriteHeader(http.StatusConflict)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
 	enc := json.NewEncoder(w)
 	err = enc.Encode(runResponse)
 	if err != nil {
@@ -117,16 +194,56 @@ func (server *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// isFormBody reports whether a request body holds parameter values rather than a program. Clients like curl send
+// form-encoded content by default, so a body that looks like JSON is still taken to be a program.
+func isFormBody(r *http.Request, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	trimmed := bytes.TrimSpace(body)
+	return mediaType == "application/x-www-form-urlencoded" && len(trimmed) > 0 && trimmed[0] != '{'
+}
+
+// parameterValues returns the first value of each request parameter, for binding program parameters.
+func parameterValues(form url.Values) map[string]string {
+	values := make(map[string]string, len(form))
+	for name, value := range form {
+		values[name] = value[0]
+	}
+	return values
+}
+
+// programContext returns ctx with what programs need to run in the server: named devices, stored programs for run
+// commands, relay arbitration, shared connections and the server's time zone.
+func (server *Server) programContext(ctx context.Context) context.Context {
+	ctx = api.WithDeviceResolver(ctx, server.Registry)
+	ctx = api.WithProgramResolver(ctx, server)
+	ctx = api.WithArbiter(ctx, server.Arbiter)
+	ctx = api.WithConnections(ctx, server.Connections)
+	return api.WithLocation(ctx, server.Location)
+}
+
+// ResolveProgram returns a stored program for a run command, loading or reloading it from disk as /run would.
+func (server *Server) ResolveProgram(ctx context.Context, slug string) (*api.Program, error) {
+	err, _, programs := server.getNamedPrograms(ctx, []string{slug})
+	if err != nil {
+		return nil, err
+	}
+	return programs[0], nil
+}
+
 func (server *Server) getNamedPrograms(ctx context.Context, slugs []string) (error, int, []*api.Program) {
 	programs := make([]*api.Program, 0)
 	for _, slug := range slugs {
 		program, exists := server.Registry.GetProgram(slug)
 		if !exists {
-			_, err := server.Registry.LoadNewProgramFromDisk(ctx, slug, server.ProgramDir)
+			loaded, err := server.Registry.LoadNewProgramFromDisk(ctx, slug, server.ProgramDir)
 			if err != nil {
 				err = fmt.Errorf("failed to load program: %w", err)
 				return err, http.StatusNotFound, nil
 			}
+			if loaded == nil {
+				return fmt.Errorf("program '%s' not found", slug), http.StatusNotFound, nil
+			}
+			program = loaded
 		}
 
 		program, err := server.Registry.ReloadProgramFromDiskIfNewer(ctx, program)
@@ -145,38 +262,97 @@ func (server *Server) getNamedPrograms(ctx context.Context, slugs []string) (err
 	return nil, 0, programs
 }
 
-func (server *Server) runPrograms(ctx context.Context, programs []*api.Program) ([]ProgramResult, []string) {
+// runPrograms runs the programs in order, recording each one's result in run as it finishes. It returns the devices
+// whose status should be reported.
+func (server *Server) runPrograms(ctx context.Context, run *Run, programs []*api.Program) []string {
 	servers := util.NewSet()
-	results := make([]ProgramResult, 0)
-	for _, program := range programs {
-		result := ProgramResult{
-			Slug:    program.Slug,
-			Program: program,
-			Status:  nil,
-			Error:   nil,
-		}
+	for i, program := range programs {
 		startTime := time.Now()
+		execution := api.NewExecution()
+		run.mutex.Lock()
+		if run.Results[i].Status == &skippedStatus {
+			run.mutex.Unlock()
+			continue
+		}
+		run.Results[i].StartTime = &startTime
+		run.Results[i].Execution = execution
+		run.mutex.Unlock()
+
 		programCtx := ctx
 		if program.Debug {
 			programCtx = context.WithValue(ctx, "debug", true)
 		}
-		err := program.Run(programCtx)
+		err := program.Run(programCtx, execution)
 		endTime := time.Now()
-		result.Slug = program.Slug
-		result.StartTime = &startTime
+		server.Throttle.Finished(program, endTime)
+
+		run.mutex.Lock()
+		result := &run.Results[i]
 		result.ExecutionTimeMillis = new(int64)
 		*result.ExecutionTimeMillis = endTime.Sub(startTime).Milliseconds()
+		result.StateBefore, result.StateAfter = execution.RelayStates()
+		result.Subprograms = subprogramResults(execution)
 		if err != nil {
-			result.Status = &errorStatus
+			result.Status = statusForError(err)
 			result.Error = new(string)
 			*result.Error = err.Error()
 		} else {
 			result.Status = &successStatus
-			servers.Add(program.Address)
+			for _, name := range program.DeviceNames(ctx) {
+				servers.Add(name)
+			}
 		}
-		results = append(results, result)
+		run.mutex.Unlock()
+	}
+	return servers.ToArray()
+}
+
+// subprogramResults returns the results of the programs called by run commands during an execution, and of those
+// they called in turn.
+func subprogramResults(execution *api.Execution) []ProgramResult {
+	subprograms := execution.Subprograms()
+	if len(subprograms) == 0 {
+		return nil
+	}
+	results := make([]ProgramResult, len(subprograms))
+	for i, subprogram := range subprograms {
+		result := ProgramResult{
+			Status:      &runningStatus,
+			StartTime:   &subprogram.StartTime,
+			Slug:        subprogram.Slug,
+			Program:     subprogram.Program,
+			Execution:   subprogram.Execution,
+			CalledFrom:  subprogram.Where,
+			Subprograms: subprogramResults(subprogram.Execution),
+		}
+		if !subprogram.EndTime.IsZero() {
+			result.ExecutionTimeMillis = new(int64)
+			*result.ExecutionTimeMillis = subprogram.EndTime.Sub(subprogram.StartTime).Milliseconds()
+			result.Status = &successStatus
+		}
+		if subprogram.Err != nil {
+			result.Status = statusForError(subprogram.Err)
+			result.Error = new(string)
+			*result.Error = subprogram.Err.Error()
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func statusForError(err error) *RunStatus {
+	var busyErr *api.BusyError
+	var preemptedErr *api.PreemptedError
+	switch {
+	case errors.As(err, &busyErr):
+		return &rejectedStatus
+	case errors.As(err, &preemptedErr):
+		return &preemptedStatus
+	case errors.Is(err, context.Canceled):
+		return &cancelledStatus
+	default:
+		return &errorStatus
 	}
-	return results, servers.ToArray()
 }
 
 func (server *Server) collectRelayStates(ctx context.Context, servers []string) map[string]*modbus.CoilStates {
@@ -187,7 +363,7 @@ func (server *Server) collectRelayStates(ctx context.Context, servers []string)
 		if len(serverAddr) == 0 {
 			continue
 		}
-		relayStates, err := modbus.GetStatus(ctx, serverAddr)
+		relayStates, err := modbus.GetStatus(ctx, api.ResolveDevice(ctx, serverAddr))
 		if err == nil {
 			relayStatesByServer[serverAddr] = relayStates
 		}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/api"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// MaxRecentRuns is how many finished runs are kept for GET /runs and GET /runs/{id}.
+const MaxRecentRuns = 50
+
+var errRunCancelled = fmt.Errorf("run cancelled by request: %w", context.Canceled)
+
+// Run is one or more programs executed in order, on request or on a schedule. It is safe to encode while the
+// programs are running; results fill in as each program finishes.
+type Run struct {
+	ID        string     `json:"id" example:"5f0c6a8e-8f2b-4c1e-9b1a-2f8e4d3c7a10"`
+	State     RunStatus  `json:"state" example:"running"`
+	StartTime time.Time  `json:"startTime" example:"2025-01-01T12:00:00Z"`
+	EndTime   *time.Time `json:"endTime,omitempty" example:"2025-01-01T12:02:00Z"`
+	RunTrigger
+	RunResponse
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+// RunTrigger says what started a run.
+type RunTrigger struct {
+	Trigger       string     `json:"trigger" example:"schedule"`
+	Schedule      string     `json:"schedule,omitempty" example:"porch-lights"`
+	ScheduledTime *time.Time `json:"scheduledTime,omitempty" example:"2025-01-01T17:00:00Z"`
+}
+
+var requestTrigger = RunTrigger{Trigger: "request"}
+
+func (run *Run) MarshalJSON() ([]byte, error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	type runJSON Run
+	return json.Marshal((*runJSON)(run))
+}
+
+// Cancel stops the run's programs, which still end their pulses and run their finally groups.
+func (run *Run) Cancel() {
+	run.cancel(errRunCancelled)
+}
+
+// Done is closed once the run has finished.
+func (run *Run) Done() <-chan struct{} {
+	return run.done
+}
+
+func (run *Run) isRunning() bool {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	return run.State == runningStatus
+}
+
+// Runs tracks active runs and remembers the most recent finished ones.
+type Runs struct {
+	active map[string]*Run
+	recent []*Run
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+}
+
+func NewRuns() *Runs {
+	return &Runs{
+		active: make(map[string]*Run),
+		recent: make([]*Run, 0),
+	}
+}
+
+// Start runs the programs in the background and returns immediately. The run is cancelled when ctx is. Programs
+// with a non-empty skip reason are reported as skipped rather than run.
+func (runs *Runs) Start(ctx context.Context, server *Server, trigger RunTrigger, programs []*api.Program, skips []string) *Run {
+	ctx, cancel := context.WithCancelCause(ctx)
+	id := uuid.New().String()
+	ctx = api.WithRunID(ctx, id)
+	run := &Run{
+		ID:         id,
+		State:      runningStatus,
+		StartTime:  time.Now(),
+		RunTrigger: trigger,
+		RunResponse: RunResponse{
+			Results: make([]ProgramResult, len(programs)),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	for i, program := range programs {
+		run.Results[i] = ProgramResult{
+			Slug:    program.Slug,
+			Program: program,
+		}
+		if skips[i] != "" {
+			run.Results[i].Status = &skippedStatus
+			run.Results[i].SkipReason = &skips[i]
+		}
+	}
+
+	runs.mutex.Lock()
+	runs.active[run.ID] = run
+	runs.wg.Add(1)
+	runs.mutex.Unlock()
+
+	util.GetLogger(ctx).Info("Starting run", "run_id", run.ID, "trigger", trigger.Trigger, "programCount", len(programs))
+	go func() {
+		defer runs.finish(run)
+		defer cancel(nil)
+		servers := server.runPrograms(ctx, run, programs)
+		relayStates := server.collectRelayStates(context.WithoutCancel(ctx), servers)
+
+		run.mutex.Lock()
+		defer run.mutex.Unlock()
+		run.Status = relayStates
+		run.State = overallStatus(run.Results)
+		endTime := time.Now()
+		run.EndTime = &endTime
+		util.GetLogger(ctx).Info("Run finished", "run_id", run.ID, "state", run.State)
+	}()
+	return run
+}
+
+func (runs *Runs) finish(run *Run) {
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	delete(runs.active, run.ID)
+	runs.recent = append(runs.recent, run)
+	if len(runs.recent) > MaxRecentRuns {
+		runs.recent = runs.recent[len(runs.recent)-MaxRecentRuns:]
+	}
+	close(run.done)
+	runs.wg.Done()
+}
+
+func (runs *Runs) Get(id string) (*Run, bool) {
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	if run, exists := runs.active[id]; exists {
+		return run, true
+	}
+	for _, run := range runs.recent {
+		if run.ID == id {
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// List returns active runs, oldest first, followed by recent finished runs, most recent first.
+func (runs *Runs) List() []*Run {
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	list := make([]*Run, 0, len(runs.active)+len(runs.recent))
+	for _, run := range runs.active {
+		list = append(list, run)
+	}
+	slices.SortFunc(list, func(a, b *Run) int {
+		return a.StartTime.Compare(b.StartTime)
+	})
+	for i := len(runs.recent) - 1; i >= 0; i-- {
+		list = append(list, runs.recent[i])
+	}
+	return list
+}
+
+// CancelAll cancels every active run.
+func (runs *Runs) CancelAll(cause error) {
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	for _, run := range runs.active {
+		run.cancel(cause)
+	}
+}
+
+// Wait blocks until all active runs have finished or ctx is done.
+func (runs *Runs) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		runs.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// overallStatus is the status of a run: cancelled if it was cancelled part-way, success if every program that ran
+// succeeded, skipped if none ran, or otherwise the status of the first program that didn't succeed.
+func overallStatus(results []ProgramResult) RunStatus {
+	status := skippedStatus
+	for _, result := range results {
+		if result.Status == nil || *result.Status == skippedStatus {
+			continue
+		}
+		if *result.Status == cancelledStatus {
+			return cancelledStatus
+		}
+		if status == skippedStatus || status == successStatus {
+			status = *result.Status
+		}
+	}
+	return status
+}
+
+// handleRuns godoc
+// @Summary      List runs
+// @Description  Returns active runs, oldest first, followed by up to 50 recently finished runs, most recent first
+// @Tags         run
+// @Produce      json
+// @Success      200 {array} server.Run
+// @Failure      500 {object} server.ErrorResponse
+// @Router       /runs [get]
+func (server *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	server.respondWithJSON(r.Context(), w, http.StatusOK, server.Runs.List())
+}
+
+// handleRunByID godoc
+// @Summary      Get or cancel a run
+// @Description  GET returns the run's progress and results. Results for programs that haven't finished yet have no
+// @Description  status; the running program's `execution` shows the command groups it has run so far.
+// @Description
+// @Description  DELETE cancels the run. Its programs stop as soon as possible, end any pending pulses, and run their
+// @Description  `finally` groups; the run's state becomes `cancelled` once they have done so.
+// @Tags         run
+// @Produce      json
+// @Param        id path string true "Run ID"
+// @Success      200 {object} server.Run
+// @Success      202 {object} server.Run "if the run was cancelled"
+// @Failure      404 {object} server.ErrorResponse "if the run is not found"
+// @Failure      409 {object} server.ErrorResponse "if the run has already finished"
+// @Router       /runs/{id} [get]
+// @Router       /runs/{id} [delete]
+func (server *Server) handleRunByID(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.PathValue("id")
+	run, exists := server.Runs.Get(id)
+	if !exists {
+		server.RespondWithError(ctx, w, http.StatusNotFound, fmt.Sprintf("Run '%s' not found", id))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if !run.isRunning() {
+			server.RespondWithError(ctx, w, http.StatusConflict, fmt.Sprintf("Run '%s' has already finished", id))
+			return
+		}
+		util.GetLogger(ctx).Info("Cancelling run", "run_id", id)
+		run.Cancel()
+		server.respondWithJSON(ctx, w, http.StatusAccepted, run)
+		return
+	}
+
+	server.respondWithJSON(ctx, w, http.StatusOK, run)
+}
+
+func (server *Server) respondWithJSON(ctx context.Context, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		util.GetLogger(ctx).Error("Failed to encode response", "error", err)
+	}
+}
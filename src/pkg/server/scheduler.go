@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/api"
+	"github.com/jakerobb/modbus-eth-controller/pkg/schedule"
+	"github.com/jakerobb/modbus-eth-controller/pkg/util"
+)
+
+// maxSchedulerSleep bounds how long the scheduler sleeps, so that changes to the schedules file and to programs are
+// picked up promptly.
+const maxSchedulerSleep = time.Minute
+
+const (
+	scheduleSourceFile    = "file"
+	scheduleSourceProgram = "program"
+)
+
+// Schedule is a schedule and the stored programs it runs, with its next and most recent fire times.
+type Schedule struct {
+	Name         string     `json:"name" example:"porch-lights"`
+	Source       string     `json:"source" example:"file"`
+	Schedule     string     `json:"schedule" example:"0 17 * * *"`
+	Programs     []string   `json:"programs" example:"porch-lights-on"`
+	TimeZone     string     `json:"timeZone" example:"America/New_York"`
+	NextFireTime *time.Time `json:"nextFireTime,omitempty" example:"2025-01-01T17:00:00-05:00"`
+	LastFireTime *time.Time `json:"lastFireTime,omitempty" example:"2024-12-31T17:00:00-05:00"`
+	LastRunID    string     `json:"lastRunId,omitempty" example:"5f0c6a8e-8f2b-4c1e-9b1a-2f8e4d3c7a10"`
+	Error        string     `json:"error,omitempty" example:"solar schedule 'sunset-30m' needs a latitude and longitude"`
+	trigger      schedule.Trigger
+}
+
+func (s *Schedule) key() string {
+	return s.Source + "/" + s.Name
+}
+
+// sortTime is the next fire time, or the far future for schedules that will never fire again.
+func (s *Schedule) sortTime() time.Time {
+	if s.NextFireTime == nil {
+		return time.Unix(1<<62, 0)
+	}
+	return *s.NextFireTime
+}
+
+func (s *Schedule) sameAs(other *Schedule) bool {
+	return s.Schedule == other.Schedule && slices.Equal(s.Programs, other.Programs)
+}
+
+// Scheduler runs stored programs at the times given by the schedules file and by programs' own schedule fields.
+// Schedules are evaluated in Location, and sunrise and sunset are computed for Site, if set. Each firing is an
+// ordinary run, listed by GET /runs, and is subject to the same relay arbitration and throttling as a request to /run.
+type Scheduler struct {
+	Location  *time.Location
+	Site      *schedule.Site
+	server    *Server
+	schedules map[string]*Schedule
+	lastCheck time.Time
+	wake      chan struct{}
+	mutex     sync.Mutex
+}
+
+func NewScheduler(server *Server, location *time.Location, site *schedule.Site) *Scheduler {
+	return &Scheduler{
+		Location:  location,
+		Site:      site,
+		server:    server,
+		schedules: make(map[string]*Schedule),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Run fires schedules until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	logger := util.GetLogger(ctx)
+	logger.Info("Starting scheduler", "timeZone", s.Location.String())
+	for {
+		s.sync(ctx)
+		now := time.Now()
+		for _, due := range s.takeDue(now) {
+			s.fire(ctx, due)
+		}
+
+		sleep := maxSchedulerSleep
+		if next, found := s.nextFireTime(); found {
+			sleep = min(sleep, time.Until(next))
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("Scheduler stopped")
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// List returns every schedule, soonest first.
+func (s *Scheduler) List(ctx context.Context) []Schedule {
+	if s.sync(ctx) {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]Schedule, 0, len(s.schedules))
+	for _, sched := range s.schedules {
+		list = append(list, *sched)
+	}
+	slices.SortFunc(list, func(a, b Schedule) int {
+		if c := a.sortTime().Compare(b.sortTime()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key(), b.key())
+	})
+	return list
+}
+
+// sync reloads the schedules file and programs, and reports whether any schedule was added or changed. Schedules
+// that are unchanged keep their fire times. New ones are due from the previous check, so a schedule added just before
+// its fire time still fires.
+func (s *Scheduler) sync(ctx context.Context) bool {
+	s.server.Registry.ReloadSchedulesFromDiskIfNewer(ctx)
+	current := make([]*Schedule, 0)
+	for name, definition := range s.server.Registry.GetSchedules() {
+		current = append(current, &Schedule{
+			Name:     name,
+			Source:   scheduleSourceFile,
+			Schedule: definition.Schedule,
+			Programs: definition.Programs,
+		})
+	}
+	programs := make(map[string]*api.Program)
+	for _, program := range s.server.Registry.RefreshProgramsFromDir(ctx, s.server.ProgramDir) {
+		programs[program.Slug] = program
+		if program.Schedule == "" {
+			continue
+		}
+		current = append(current, &Schedule{
+			Name:     program.Slug,
+			Source:   scheduleSourceProgram,
+			Schedule: program.Schedule,
+			Programs: []string{program.Slug},
+		})
+	}
+
+	problems := make(map[string]string, len(current))
+	for _, sched := range current {
+		problems[sched.key()] = s.checkPrograms(ctx, sched, programs)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	from := s.lastCheck
+	if from.IsZero() {
+		from = time.Now()
+	}
+	changed := false
+	schedules := make(map[string]*Schedule, len(current))
+	for _, sched := range current {
+		if existing, exists := s.schedules[sched.key()]; exists && existing.sameAs(sched) {
+			schedules[sched.key()] = existing
+			if existing.trigger != nil && existing.Error != problems[sched.key()] {
+				existing.Error = problems[sched.key()]
+				logProgramProblem(ctx, existing)
+			}
+			continue
+		}
+		sched.TimeZone = s.Location.String()
+		schedules[sched.key()] = sched
+		changed = true
+		trigger, err := schedule.Parse(sched.Schedule, s.Site)
+		if err != nil {
+			sched.Error = err.Error()
+			util.GetLogger(ctx).Error("Invalid schedule. It won't fire.", "schedule", sched.key(), "error", err)
+			continue
+		}
+		sched.trigger = trigger
+		sched.NextFireTime = s.next(trigger, from)
+		sched.Error = problems[sched.key()]
+		logProgramProblem(ctx, sched)
+		util.GetLogger(ctx).Info("Scheduled programs",
+			"schedule", sched.key(),
+			"expression", sched.Schedule,
+			"programs", sched.Programs,
+			"nextFireTime", sched.NextFireTime)
+	}
+	s.schedules = schedules
+	return changed
+}
+
+// checkPrograms returns why the schedule's programs can't run as they stand, such as a required parameter with no
+// default, or "" if they can.
+func (s *Scheduler) checkPrograms(ctx context.Context, sched *Schedule, programs map[string]*api.Program) string {
+	ctx = s.server.programContext(ctx)
+	for _, slug := range sched.Programs {
+		program, exists := programs[slug]
+		if !exists {
+			return fmt.Sprintf("program '%s' not found", slug)
+		}
+		if err := program.Validate(ctx); err != nil {
+			return fmt.Sprintf("invalid program '%s': %v", slug, err)
+		}
+	}
+	return ""
+}
+
+// logProgramProblem logs a schedule whose programs can't run, once each time the problem is found or changes.
+func logProgramProblem(ctx context.Context, sched *Schedule) {
+	if sched.Error != "" {
+		util.GetLogger(ctx).Error("Scheduled programs can't run. The schedule will fail until they are fixed.",
+			"schedule", sched.key(), "error", sched.Error)
+	}
+}
+
+// takeDue returns the schedules whose fire time has come, and advances them to their next fire time.
+func (s *Scheduler) takeDue(now time.Time) []Schedule {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastCheck = now
+	due := make([]Schedule, 0)
+	for _, sched := range s.schedules {
+		if sched.NextFireTime == nil || sched.NextFireTime.After(now) {
+			continue
+		}
+		due = append(due, *sched)
+		sched.LastFireTime = sched.NextFireTime
+		sched.NextFireTime = s.next(sched.trigger, now)
+	}
+	return due
+}
+
+func (s *Scheduler) next(trigger schedule.Trigger, after time.Time) *time.Time {
+	next := trigger.Next(after.In(s.Location))
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+func (s *Scheduler) nextFireTime() (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var next time.Time
+	for _, sched := range s.schedules {
+		if sched.NextFireTime != nil && (next.IsZero() || sched.NextFireTime.Before(next)) {
+			next = *sched.NextFireTime
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// fire starts a run of the schedule's programs. Programs that can't be found or are invalid are logged and the
+// schedule is skipped this time.
+func (s *Scheduler) fire(ctx context.Context, sched Schedule) {
+	logger := util.GetLogger(ctx).With("schedule", sched.key())
+	ctx = util.WithLogger(s.server.programContext(ctx), logger)
+
+	err, _, programs := s.server.getNamedPrograms(ctx, sched.Programs)
+	if err == nil {
+		for _, program := range programs {
+			if err = program.Validate(ctx); err != nil {
+				err = fmt.Errorf("invalid program '%s': %w", program.Slug, err)
+				break
+			}
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to run scheduled programs", "error", err)
+		return
+	}
+
+	now := time.Now()
+	skips := make([]string, len(programs))
+	for i, program := range programs {
+		skips[i] = s.server.Throttle.Check(program, now)
+	}
+	trigger := RunTrigger{
+		Trigger:       "schedule",
+		Schedule:      sched.Name,
+		ScheduledTime: sched.NextFireTime,
+	}
+	run := s.server.Runs.Start(ctx, s.server, trigger, programs, skips)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, exists := s.schedules[sched.key()]; exists {
+		current.LastRunID = run.ID
+	}
+}
+
+// handleSchedules godoc
+// @Summary      List schedules
+// @Description  Returns the schedules from the schedules file and from programs' `schedule` fields, soonest first,
+// @Description  with their next fire times in the scheduler's time zone. For `sunrise` and `sunset` schedules, this is
+// @Description  the computed time of the next event, plus the offset. `lastRunId` identifies the most recent scheduled
+// @Description  run; see `/runs/{id}`. Schedules that can't fire, or whose programs can't run, have an
+// @Description  `error`.
+// @Tags         schedules
+// @Produce      json
+// @Success      200 {array} server.Schedule
+// @Failure      500 {object} server.ErrorResponse
+// @Router       /schedules [get]
+func (server *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	server.respondWithJSON(ctx, w, http.StatusOK, server.Scheduler.List(ctx))
+}
@@ -3,16 +3,27 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	// embedded so that MODBUS_TIMEZONE works in the from-scratch Docker image, which has no zoneinfo
+	_ "time/tzdata"
 
 	"github.com/google/uuid"
 	"github.com/swaggo/http-swagger"
 
 	_ "github.com/jakerobb/modbus-eth-controller/docs"
+	"github.com/jakerobb/modbus-eth-controller/pkg/api"
+	"github.com/jakerobb/modbus-eth-controller/pkg/schedule"
 	"github.com/jakerobb/modbus-eth-controller/pkg/server/registry"
 	"github.com/jakerobb/modbus-eth-controller/pkg/util"
 )
@@ -20,6 +31,12 @@ import (
 type Server struct {
 	ProgramDir  string
 	Registry    *registry.Registry
+	Runs        *Runs
+	Arbiter     *api.Arbiter
+	Connections *api.Connections
+	Throttle    *Throttle
+	Scheduler   *Scheduler
+	Location    *time.Location
 	AllowOrigin string
 	Logger      *slog.Logger
 }
@@ -35,6 +52,16 @@ func InitServer() *Server {
 		programDir = "/etc/modbus"
 	}
 
+	devicesFile := os.Getenv("MODBUS_DEVICES_FILE")
+	if devicesFile == "" {
+		devicesFile = filepath.Join(programDir, "devices.json")
+	}
+
+	schedulesFile := os.Getenv("MODBUS_SCHEDULES_FILE")
+	if schedulesFile == "" {
+		schedulesFile = filepath.Join(programDir, "schedules.json")
+	}
+
 	allowOrigin := os.Getenv("ALLOW_ORIGIN")
 	if allowOrigin == "" {
 		allowOrigin = "*"
@@ -42,17 +69,59 @@ func InitServer() *Server {
 
 	logger := slog.Default().With("component", "server")
 
+	location := time.Local
+	if timeZone := os.Getenv("MODBUS_TIMEZONE"); timeZone != "" {
+		var err error
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			logger.Error("Unknown time zone. Using the system time zone.", "timeZone", timeZone, "error", err)
+			location = time.Local
+		}
+	}
+
 	server := &Server{
 		ProgramDir:  programDir,
 		Registry:    registry.NewRegistry(),
+		Runs:        NewRuns(),
+		Arbiter:     api.NewArbiter(),
+		Connections: api.NewConnections(),
+		Throttle:    NewThrottle(),
+		Location:    location,
 		AllowOrigin: allowOrigin,
 		Logger:      logger,
 	}
+	server.Scheduler = NewScheduler(server, location, readSite(logger))
 
-	server.Registry.LoadProgramsFromDir(util.WithLogger(context.Background(), logger), programDir)
+	ctx := util.WithLogger(context.Background(), logger)
+	server.Registry.LoadDevicesFromFile(ctx, devicesFile)
+	server.Registry.LoadSchedulesFromFile(ctx, schedulesFile)
+	server.Registry.LoadProgramsFromDir(ctx, programDir)
 	return server
 }
 
+// readSite reads the latitude and longitude for sunrise and sunset schedules. Without them, such schedules don't fire.
+func readSite(logger *slog.Logger) *schedule.Site {
+	latitude, longitude := os.Getenv("MODBUS_LATITUDE"), os.Getenv("MODBUS_LONGITUDE")
+	if latitude == "" && longitude == "" {
+		return nil
+	}
+	site := &schedule.Site{}
+	var err error
+	if site.Latitude, err = strconv.ParseFloat(latitude, 64); err != nil {
+		logger.Error("Invalid MODBUS_LATITUDE. Sunrise and sunset schedules won't fire.", "latitude", latitude, "error", err)
+		return nil
+	}
+	if site.Longitude, err = strconv.ParseFloat(longitude, 64); err != nil {
+		logger.Error("Invalid MODBUS_LONGITUDE. Sunrise and sunset schedules won't fire.", "longitude", longitude, "error", err)
+		return nil
+	}
+	if err = site.Validate(); err != nil {
+		logger.Error("Invalid site. Sunrise and sunset schedules won't fire.", "error", err)
+		return nil
+	}
+	return site
+}
+
 func (server *Server) handle(path string, h http.HandlerFunc, methods ...string) {
 	handler := server.wrapWithLogging(h)
 	handler = server.wrapWithCors(handler, methods...)
@@ -122,20 +191,54 @@ func (server *Server) Start() {
 	}
 
 	server.handle("/run", server.handleRun, "GET", "POST")
+	server.handle("/runs", server.handleRuns, "GET")
+	server.handle("/runs/{id}", server.handleRunByID, "GET", "DELETE")
+	server.handle("/locks", server.handleLocks, "GET")
+	server.handle("/schedules", server.handleSchedules, "GET")
 	server.handle("/programs", server.handlePrograms, "GET")
 	server.handle("/status", server.handleStatus, "GET")
 	server.handle("/", http.FileServer(http.FS(staticContent)).ServeHTTP, "GET")
 	server.handle("/swagger/", httpSwagger.WrapHandler.ServeHTTP, "GET")
 
+	// Request contexts derive from ctx, so running programs are cancelled (and run their finally groups) on shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{
+		Addr: fmt.Sprintf("%s:%s", listenAddr, listenPort),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go server.Scheduler.Run(util.WithLogger(ctx, slog.Default().With("component", "scheduler")))
+
+	shutdownComplete := make(chan struct{})
+	go func() {
+		defer close(shutdownComplete)
+		<-ctx.Done()
+		server.Logger.Info("Shutting down; waiting for running programs to stop")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), api.FinallyTimeout+5*time.Second)
+		defer cancel()
+		server.Runs.CancelAll(context.Cause(ctx))
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			server.Logger.Error("Failed to shut down cleanly", "error", err)
+		}
+		if err := server.Runs.Wait(shutdownCtx); err != nil {
+			server.Logger.Error("Timed out waiting for runs to stop", "error", err)
+		}
+	}()
+
 	server.Logger.Info("Starting server",
 		"address", listenAddr,
 		"port", listenPort,
 	)
-	err := http.ListenAndServe(fmt.Sprintf("%s:%s", listenAddr, listenPort), nil)
-	if err != nil {
+	err := httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("Failed to start server", "error", err)
 		os.Exit(1)
 	}
+	<-shutdownComplete
+	server.Logger.Info("Server stopped")
 }
 
 func (server *Server) RespondWithError(ctx context.Context, w http.ResponseWriter, status int, message string) {
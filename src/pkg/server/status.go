@@ -15,8 +15,9 @@ import (
 // @Description  Returns the current state of all relays for the specified Modbus device
 // @Tags         status
 // @Produce      json
-// @Param        address query string true "Modbus device IP or hostname and port number"
+// @Param        address query string true "Device name, or Modbus device IP or hostname and port number"
 // @Success      200 {object} modbus.CoilStates
+// @Failure      400 {object} server.ErrorResponse "if no address is given"
 // @Failure      500 {object} server.ErrorResponse
 // @Router       /status [get]
 func (server *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
@@ -27,7 +28,12 @@ func (server *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	if debugParam == "true" {
 		ctx = context.WithValue(ctx, "debug", true)
 	}
-	relayStates, err := modbus.GetStatus(ctx, addr)
+	if addr == "" {
+		server.RespondWithError(ctx, w, http.StatusBadRequest, "Missing required parameter: address")
+		return
+	}
+	device := server.Registry.ResolveDevice(ctx, addr)
+	relayStates, err := modbus.GetStatus(ctx, device)
 	if err != nil {
 		server.RespondWithError(ctx, w, http.StatusInternalServerError, fmt.Sprintf("Failed to read relay states: %v", err))
 		return
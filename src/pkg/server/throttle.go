@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jakerobb/modbus-eth-controller/pkg/api"
+)
+
+// Throttle enforces each stored program's debounceMillis, cooldownMillis and maxRunsPerMinute, so that a burst of
+// triggers (e.g. a webhook firing several times in a second) runs the program only once. Programs are tracked by
+// slug; ad-hoc programs aren't throttled.
+type Throttle struct {
+	history map[string]*triggerHistory
+	mutex   sync.Mutex
+}
+
+type triggerHistory struct {
+	lastTrigger time.Time
+	lastFinish  time.Time
+	starts      []time.Time
+	// running counts the runs Check has admitted that haven't Finished; the cooldown lasts until they have.
+	running int
+}
+
+func NewThrottle() *Throttle {
+	return &Throttle{
+		history: make(map[string]*triggerHistory),
+	}
+}
+
+// Check records a trigger of the program, and returns the reason it should be skipped, or "" if it may run.
+func (t *Throttle) Check(program *api.Program, now time.Time) string {
+	if program.Path == "" {
+		return ""
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	history, exists := t.history[program.Slug]
+	if !exists {
+		history = &triggerHistory{}
+		t.history[program.Slug] = history
+	}
+
+	// every trigger restarts the debounce window, including skipped ones
+	lastTrigger := history.lastTrigger
+	history.lastTrigger = now
+	if debounce := millis(program.DebounceMillis); debounce > 0 && now.Sub(lastTrigger) < debounce {
+		return fmt.Sprintf("debounced: triggered again %d ms after the previous trigger (debounceMillis %d)",
+			now.Sub(lastTrigger).Milliseconds(), program.DebounceMillis)
+	}
+	if cooldown := millis(program.CooldownMillis); cooldown > 0 {
+		if history.running > 0 {
+			return fmt.Sprintf("cooling down: last run hasn't finished (cooldownMillis %d)", program.CooldownMillis)
+		}
+		if now.Sub(history.lastFinish) < cooldown {
+			return fmt.Sprintf("cooling down: last run finished %d ms ago (cooldownMillis %d)",
+				now.Sub(history.lastFinish).Milliseconds(), program.CooldownMillis)
+		}
+	}
+
+	recent := history.starts[:0]
+	for _, start := range history.starts {
+		if now.Sub(start) < time.Minute {
+			recent = append(recent, start)
+		}
+	}
+	history.starts = recent
+	if program.MaxRunsPerMinute > 0 && len(history.starts) >= program.MaxRunsPerMinute {
+		return fmt.Sprintf("rate limited: already ran %d times in the last minute (maxRunsPerMinute %d)",
+			len(history.starts), program.MaxRunsPerMinute)
+	}
+	history.starts = append(history.starts, now)
+	history.running++
+	return ""
+}
+
+// Finished records the end of a run of the program that Check admitted, from which its cooldown is measured.
+func (t *Throttle) Finished(program *api.Program, now time.Time) {
+	if program.Path == "" {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if history, exists := t.history[program.Slug]; exists {
+		history.lastFinish = now
+		history.running = max(history.running-1, 0)
+	}
+}
+
+func millis(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
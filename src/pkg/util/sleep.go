@@ -0,0 +1,18 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// Sleep pauses for the given duration, or until ctx is done, in which case it returns the reason.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}